@@ -0,0 +1,304 @@
+// Program gokr-rsync is an rsync implementation which can act as a client
+// (copying from or to a remote rsync via a remote shell, or between two local
+// directories), as a server (when started as rsync-path, e.g. via ssh) or as
+// an rsync daemon (serving modules via rsync://).
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/picosh/go-rsync-receiver/localfs"
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/rsyncclient"
+	"github.com/picosh/go-rsync-receiver/rsyncd"
+	"github.com/picosh/go-rsync-receiver/rsyncopts"
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+// defaultPort is the rsync daemon’s TCP port (rsync/rsync.h:RSYNC_PORT).
+const defaultPort = 873
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "gokr-rsync: %v\n", err)
+		os.Exit(rsync.ExitCode(err))
+	}
+}
+
+func run(args []string) error {
+	pc, err := rsyncopts.ParseArguments(args, true)
+	if err != nil {
+		return rsync.Errorf(rsync.RERR_SYNTAX, "%v", err)
+	}
+	opts := pc.Options
+	if opts.Daemon() || opts.Gokrazy.Listen != "" {
+		return runDaemon(slog.New(slog.NewTextHandler(os.Stderr, nil)), opts)
+	}
+	// Clients and servers report problems to the user via errors and
+	// protocol messages; the library’s diagnostics would only add noise.
+	logger := slog.New(slog.DiscardHandler)
+	if opts.Server() {
+		return runServer(logger, opts, args, pc.RemainingArgs)
+	}
+	return runClient(logger, opts, pc.RemainingArgs)
+}
+
+// withoutGokrazyFlags returns args without the --gokr.* flags, which are
+// configuring this program instead of the transfer.
+func withoutGokrazyFlags(args []string) []string {
+	var filtered []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(filtered, args[i:]...)
+		}
+		if !strings.HasPrefix(arg, "--gokr.") {
+			filtered = append(filtered, arg)
+			continue
+		}
+		if !strings.Contains(arg, "=") {
+			i++ // skip the flag’s value
+		}
+	}
+	return filtered
+}
+
+// stdio is the connection of a server started by a remote shell.
+type stdio struct {
+	io.Reader
+	io.Writer
+}
+
+// rsync/main.c:start_server
+func runServer(logger *slog.Logger, opts *rsyncopts.Options, rawArgs, args []string) error {
+	srv, err := server(logger, opts)
+	if err != nil {
+		return err
+	}
+	// The first argument is the working directory, which is always ".".
+	paths := args
+	if len(paths) > 0 && paths[0] == "." {
+		paths = paths[1:]
+	}
+	if srv != nil {
+		// With a configuration, paths start with a module name, like with
+		// the daemon. Users are not authenticated.
+		return srv.HandleServer(stdio{os.Stdin, os.Stdout}, "", withoutGokrazyFlags(rawArgs))
+	}
+	var filesystem utils.FS
+	if opts.Sender() {
+		fsys, names, err := sources(paths)
+		if err != nil {
+			return err
+		}
+		filesystem, paths = fsys, names
+	} else {
+		dest := "."
+		if len(paths) > 0 {
+			dest = paths[0]
+		}
+		filesystem = localfs.New(dest)
+	}
+	return rsyncd.RunServer(logger, opts, paths, stdio{os.Stdin, os.Stdout}, filesystem, true)
+}
+
+// server returns the Server for the modules configured by --gokr.config (or
+// --config) and --gokr.modulemap, or nil if there are none.
+func server(logger *slog.Logger, opts *rsyncopts.Options) (*rsyncd.Server, error) {
+	cfg := &rsyncd.Config{}
+	if path := cmp.Or(opts.Gokrazy.Config, opts.ConfigFile()); path != "" {
+		var err error
+		cfg, err = rsyncd.LoadConfig(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	modules, err := rsyncd.ParseModuleMap(opts.Gokrazy.ModuleMap)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Modules = append(cfg.Modules, modules...)
+	if err := cfg.Validate(nil); err != nil {
+		return nil, err
+	}
+	if len(cfg.Modules) == 0 {
+		return nil, nil
+	}
+	if opts.Gokrazy.Listen == "" {
+		opts.Gokrazy.Listen = cfg.Listen
+	}
+	return &rsyncd.Server{
+		Modules: cfg.Modules,
+		Logger:  logger,
+	}, nil
+}
+
+// rsync/clientserver.c:daemon_main
+func runDaemon(logger *slog.Logger, opts *rsyncopts.Options) error {
+	srv, err := server(logger, opts)
+	if err != nil {
+		return err
+	}
+	if srv == nil {
+		return rsync.Errorf(rsync.RERR_SYNTAX, "no modules configured, use --gokr.config or --gokr.modulemap")
+	}
+	addr := opts.Gokrazy.Listen
+	if addr == "" {
+		port := opts.Port()
+		if port == 0 {
+			port = defaultPort
+		}
+		addr = net.JoinHostPort(opts.BindAddress(), strconv.Itoa(port))
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return rsync.Errorf(rsync.RERR_SOCKETIO, "%v", err)
+	}
+	logger.Info("rsync daemon listening", "addr", ln.Addr(), "modules", len(srv.Modules))
+	return srv.Serve(ln)
+}
+
+// rsync/main.c:start_client
+func runClient(logger *slog.Logger, opts *rsyncopts.Options, args []string) error {
+	if len(args) == 0 {
+		return rsync.Errorf(rsync.RERR_SYNTAX, "usage: gokr-rsync [OPTION]... SRC [SRC]... DEST")
+	}
+	srcArgs, dest := args[:len(args)-1], args[len(args)-1]
+	if len(args) == 1 {
+		// rsync lists the files of a single source argument.
+		srcArgs, dest = args, ""
+		if !opts.ListOnly() {
+			return rsync.Errorf(rsync.RERR_SYNTAX, "listing files requires --list-only")
+		}
+	}
+
+	var (
+		srcHost  string
+		srcPaths []string
+	)
+	for i, arg := range srcArgs {
+		host, path, err := hostspec(arg)
+		if err != nil {
+			return err
+		}
+		if i > 0 && host != srcHost {
+			return rsync.Errorf(rsync.RERR_SYNTAX, "all source paths must be on the same host")
+		}
+		srcHost = host
+		srcPaths = append(srcPaths, path)
+	}
+	destHost, destPath, err := hostspec(dest)
+	if err != nil {
+		return err
+	}
+
+	var stats *rsyncstats.TransferStats
+	switch {
+	case srcHost != "" && destHost != "":
+		return rsync.Errorf(rsync.RERR_SYNTAX, "the source and destination cannot both be remote")
+
+	case destHost != "":
+		opts.SetSender()
+		src, names, err := sources(srcPaths)
+		if err != nil {
+			return err
+		}
+		stats, err = remote(logger, opts, destHost, []string{destPath}, src, names)
+		if err != nil {
+			return err
+		}
+
+	case srcHost != "":
+		var dst utils.FS
+		if !opts.ListOnly() {
+			dst = localfs.New(destPath)
+		}
+		stats, err = remote(logger, opts, srcHost, srcPaths, dst, nil)
+		if err != nil {
+			return err
+		}
+
+	default:
+		if dest == "" {
+			return rsync.Errorf(rsync.RERR_UNSUPPORTED, "listing local files is not supported")
+		}
+		src, names, err := sources(srcPaths)
+		if err != nil {
+			return err
+		}
+		stats, err = rsyncclient.RunLocal(logger, opts, src, names, localfs.New(destPath))
+		if err != nil {
+			return err
+		}
+	}
+
+	if level := opts.StatsLevel(); level > 0 && !opts.ListOnly() {
+		fmt.Fprint(os.Stdout, stats.Summary(level > 1))
+	}
+	return nil
+}
+
+// remote runs a transfer with an rsync server started via the remote shell
+// on host, which handles remotePaths.
+func remote(logger *slog.Logger, opts *rsyncopts.Options, host string, remotePaths []string, filesystem utils.FS, paths []string) (*rsyncstats.TransferStats, error) {
+	cmd, err := rsyncclient.ServerCommand(opts, host, remotePaths)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = os.Stderr
+	conn, err := rsyncclient.Command(cmd)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := rsyncclient.Run(logger, opts, conn, filesystem, paths)
+	if cerr := conn.Close(); err == nil {
+		err = cerr
+	}
+	return stats, err
+}
+
+// rsync/main.c:check_for_hostspec
+//
+// hostspec splits a [USER@]HOST:PATH argument into host and path. host is
+// empty for local paths, i.e. those in which a slash precedes the colon.
+func hostspec(arg string) (host, path string, err error) {
+	if strings.HasPrefix(arg, "rsync://") {
+		return "", "", rsync.Errorf(rsync.RERR_UNSUPPORTED, "connecting to an rsync daemon is not supported: %s", arg)
+	}
+	colon := strings.IndexByte(arg, ':')
+	if colon <= 0 || strings.Contains(arg[:colon], "/") {
+		return "", arg, nil
+	}
+	host, path = arg[:colon], arg[colon+1:]
+	if strings.HasPrefix(path, ":") {
+		return "", "", rsync.Errorf(rsync.RERR_UNSUPPORTED, "connecting to an rsync daemon is not supported: %s", arg)
+	}
+	return host, path, nil
+}
+
+// sources returns the file system and names from which to send the local
+// paths, which are relative to the working directory.
+func sources(paths []string) (*localfs.FS, []string, error) {
+	abs := make([]string, len(paths))
+	for i, path := range paths {
+		p, err := filepath.Abs(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if path == "" || path == "." || strings.HasSuffix(path, "/") {
+			// Keep the trailing slash, which selects the directory’s contents.
+			p += "/"
+		}
+		abs[i] = filepath.ToSlash(p)
+	}
+	return localfs.Sources("/", abs)
+}
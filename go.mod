@@ -3,6 +3,10 @@ module github.com/picosh/go-rsync-receiver
 go 1.24
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/mmcloughlin/md4 v0.1.2
+	golang.org/x/crypto v0.31.0
 	golang.org/x/sync v0.11.0
 )
+
+require golang.org/x/sys v0.28.0 // indirect
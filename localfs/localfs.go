@@ -0,0 +1,297 @@
+// Package localfs implements utils.FS on a directory of the local file
+// system, e.g. for local copies and for the command-line tool.
+package localfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+// FS stores files below the directory Root. Names are relative to Root and
+// cannot refer to files outside of it. Symbolic links below Root are never
+// followed as directories, so that a link received from a client cannot
+// redirect later accesses outside of Root (rsync relies on a chroot or on
+// munged symlinks for this).
+type FS struct {
+	Root string
+}
+
+var (
+	_ utils.FS         = (*FS)(nil)
+	_ utils.Deleter    = (*FS)(nil)
+	_ utils.Linker     = (*FS)(nil)
+	_ utils.Chowner    = (*FS)(nil)
+	_ utils.Readlinker = (*FS)(nil)
+)
+
+// New returns an FS for the directory root.
+func New(root string) *FS {
+	return &FS{Root: root}
+}
+
+// Sources returns the FS and the names within it from which a sender
+// transmits paths (relative to root) like rsync does: the contents of a
+// directory if its path ends in a slash (or is “.”), the file or directory
+// itself otherwise. All paths must be in the same directory.
+func Sources(root string, paths []string) (*FS, []string, error) {
+	var (
+		fsys  *FS
+		names []string
+	)
+	for _, path := range paths {
+		full := filepath.Join(root, filepath.Clean("/"+filepath.FromSlash(path)))
+		dir, name := filepath.Dir(full), filepath.Base(full)
+		if path == "" || path == "." || strings.HasSuffix(path, "/") {
+			dir, name = full, "."
+		}
+		if fsys != nil && fsys.Root != dir {
+			return nil, nil, rsync.Errorf(rsync.RERR_UNSUPPORTED, "source paths in different directories are not supported: %s", path)
+		}
+		fsys = New(dir)
+		names = append(names, name)
+	}
+	if fsys == nil {
+		return nil, nil, rsync.Errorf(rsync.RERR_SYNTAX, "no source paths given")
+	}
+	return fsys, names, nil
+}
+
+// resolve returns the path of name below Root after checking that none of
+// its parent directories below Root is a symbolic link. With create, Root and
+// missing parent directories are created. The last component of name is not
+// checked: callers must not follow it if it is a symbolic link.
+func (fsys *FS) resolve(name string, create bool) (string, error) {
+	rel := strings.TrimPrefix(filepath.Clean("/"+filepath.FromSlash(name)), string(filepath.Separator))
+	if rel == "" {
+		if create {
+			return fsys.Root, os.MkdirAll(fsys.Root, 0o755)
+		}
+		return fsys.Root, nil
+	}
+	if create {
+		if err := os.MkdirAll(fsys.Root, 0o755); err != nil {
+			return "", err
+		}
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	path := fsys.Root
+	for _, part := range parts[:len(parts)-1] {
+		path = filepath.Join(path, part)
+		fi, err := os.Lstat(path)
+		if create && errors.Is(err, fs.ErrNotExist) {
+			if err := os.Mkdir(path, 0o755); err != nil && !errors.Is(err, fs.ErrExist) {
+				return "", err
+			}
+			fi, err = os.Lstat(path)
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			return "", &os.PathError{Op: "resolve", Path: path, Err: errors.New("refusing to follow symbolic link")}
+		}
+		if !fi.IsDir() {
+			return "", &os.PathError{Op: "resolve", Path: path, Err: syscall.ENOTDIR}
+		}
+	}
+	return filepath.Join(path, parts[len(parts)-1]), nil
+}
+
+type namedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi *namedFileInfo) Name() string { return fi.name }
+
+// List returns name and, if name is a directory, all files below it. The
+// returned file infos are named relative to Root, with Root itself named "/"
+// (which the sender transmits as “.”).
+func (fsys *FS) List(name string) ([]os.FileInfo, error) {
+	root, err := fsys.resolve(name, false)
+	if err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(fsys.Root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			rel = "/"
+		}
+		infos = append(infos, &namedFileInfo{FileInfo: info, name: filepath.ToSlash(rel)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// Read opens the file f.WPath for reading. Symbolic links are not followed.
+func (fsys *FS) Read(f *utils.SenderFile) (os.FileInfo, utils.ReaderAtCloser, error) {
+	path, err := fsys.resolve(f.WPath, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		return nil, nil, &os.PathError{Op: "open", Path: path, Err: errors.New("is a symbolic link")}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fi, file, nil
+}
+
+// Put creates or replaces the file f. Regular files are written to a
+// temporary file, which is renamed into place once f.Reader is exhausted. A
+// directory replaces a symbolic link or other file of the same name.
+func (fsys *FS) Put(f *utils.ReceiverFile) (int64, error) {
+	mode := f.FileMode()
+	if !mode.IsDir() && mode&fs.ModeSymlink == 0 && !mode.IsRegular() {
+		return 0, fmt.Errorf("%s: unsupported file type %v", f.Name, mode.Type())
+	}
+	path, err := fsys.resolve(f.Name, true)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case mode.IsDir():
+		// rsync/generator.c:recv_generator replaces non-directories
+		if fi, err := os.Lstat(path); err == nil && !fi.IsDir() {
+			if err := os.Remove(path); err != nil {
+				return 0, err
+			}
+		}
+		if err := os.Mkdir(path, 0o755); err != nil && !errors.Is(err, fs.ErrExist) {
+			return 0, err
+		}
+		return 0, os.Chmod(path, mode.Perm())
+
+	case mode&fs.ModeSymlink != 0:
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return 0, err
+		}
+		return 0, os.Symlink(f.LinkTarget, path)
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) // fails once renamed
+	var n int64
+	if f.Reader != nil {
+		n, err = io.Copy(tmp, f.Reader)
+		if err != nil {
+			tmp.Close()
+			return n, err
+		}
+	}
+	if err := tmp.Chmod(mode.Perm()); err != nil {
+		tmp.Close()
+		return n, err
+	}
+	if err := tmp.Close(); err != nil {
+		return n, err
+	}
+	if !f.ModTime.IsZero() {
+		if err := os.Chtimes(tmp.Name(), f.ModTime, f.ModTime); err != nil {
+			return n, err
+		}
+	}
+	return n, os.Rename(tmp.Name(), path)
+}
+
+// Remove deletes all files below Root which are not in fileList.
+func (fsys *FS) Remove(fileList []*utils.ReceiverFile) error {
+	infos, err := fsys.List("/")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	// Remove files before the directories containing them.
+	for i := len(infos) - 1; i >= 0; i-- {
+		name := infos[i].Name()
+		if name == "/" || utils.FindInFileList(fileList, name) {
+			continue
+		}
+		path, err := fsys.resolve(name, false)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the file or empty directory name.
+func (fsys *FS) Delete(name string) error {
+	path, err := fsys.resolve(name, false)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Link creates newname as a hard link to oldname.
+func (fsys *FS) Link(oldname, newname string) error {
+	oldpath, err := fsys.resolve(oldname, false)
+	if err != nil {
+		return err
+	}
+	path, err := fsys.resolve(newname, true)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.Link(oldpath, path)
+}
+
+// Lchown changes the owner of name, not following symbolic links.
+func (fsys *FS) Lchown(name string, uid, gid int) error {
+	path, err := fsys.resolve(name, false)
+	if err != nil {
+		return err
+	}
+	return os.Lchown(path, uid, gid)
+}
+
+// Readlink returns the target of the symbolic link name.
+func (fsys *FS) Readlink(name string) (string, error) {
+	path, err := fsys.resolve(name, false)
+	if err != nil {
+		return "", err
+	}
+	return os.Readlink(path)
+}
@@ -0,0 +1,74 @@
+package localfs_test
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/picosh/go-rsync-receiver/localfs"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+var mode = utils.ModeFromFileMode
+
+func TestSymlinkEscape(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Chmod(outside, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fsys := localfs.New(root)
+
+	// A first transfer creates a symbolic link to outside of the root…
+	if _, err := fsys.Put(&utils.ReceiverFile{Name: "evil", Mode: mode(fs.ModeSymlink | 0o777), LinkTarget: outside}); err != nil {
+		t.Fatal(err)
+	}
+
+	// …through which later accesses must not reach.
+	if _, err := fsys.Put(&utils.ReceiverFile{Name: "evil/x", Mode: mode(0o644), Reader: strings.NewReader("x")}); err == nil {
+		t.Errorf("Put(evil/x) through a symbolic link succeeded")
+	}
+	if _, err := fsys.Put(&utils.ReceiverFile{Name: "evil/sub/x", Mode: mode(0o644), Reader: strings.NewReader("x")}); err == nil {
+		t.Errorf("Put(evil/sub/x) through a symbolic link succeeded")
+	}
+	if err := fsys.Link("evil/secret", "stolen"); err == nil {
+		t.Errorf("Link(evil/secret) through a symbolic link succeeded")
+	}
+	if _, rd, err := fsys.Read(&utils.SenderFile{WPath: "evil/secret"}); err == nil {
+		rd.Close()
+		t.Errorf("Read(evil/secret) through a symbolic link succeeded")
+	}
+	if _, err := fsys.List("evil/secret"); err == nil {
+		t.Errorf("List(evil/secret) through a symbolic link succeeded")
+	}
+
+	// A directory replaces the symbolic link instead of changing its target.
+	if _, err := fsys.Put(&utils.ReceiverFile{Name: "evil", Mode: mode(fs.ModeDir | 0o777)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Put(&utils.ReceiverFile{Name: "evil/x", Mode: mode(0o644), Reader: strings.NewReader("x")}); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Lstat(filepath.Join(root, "evil")); err != nil || !fi.IsDir() {
+		t.Errorf("evil is not a directory: %v, %v", fi, err)
+	}
+	if b, err := os.ReadFile(filepath.Join(root, "evil", "x")); err != nil || string(b) != "x" {
+		t.Errorf("evil/x = %q, %v", b, err)
+	}
+
+	entries, err := os.ReadDir(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "secret" {
+		t.Errorf("files were created outside of the root: %v", entries)
+	}
+	if fi, err := os.Stat(outside); err != nil || fi.Mode().Perm() != 0o700 {
+		t.Errorf("permissions outside of the root changed: %v, %v", fi, err)
+	}
+}
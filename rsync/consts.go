@@ -29,6 +29,39 @@ const (
 	S_IFSOCK = 0o0140000 // Socket
 )
 
+// I/O error flags, sent at the end of the file list or with MSG_IO_ERROR.
+const (
+	IOERR_GENERAL   = (1 << 0) // For backward compatibility, this must == 1
+	IOERR_VANISHED  = (1 << 1)
+	IOERR_DEL_LIMIT = (1 << 2)
+)
+
+// Itemize flags (rsync.h), describing what changed about a file.
+const (
+	ITEM_REPORT_ATIME       = (1 << 0)
+	ITEM_REPORT_CHANGE      = (1 << 1)
+	ITEM_REPORT_SIZE        = (1 << 2) // regular files only
+	ITEM_REPORT_TIMEFAIL    = (1 << 2) // symlinks only
+	ITEM_REPORT_TIME        = (1 << 3)
+	ITEM_REPORT_PERMS       = (1 << 4)
+	ITEM_REPORT_OWNER       = (1 << 5)
+	ITEM_REPORT_GROUP       = (1 << 6)
+	ITEM_REPORT_ACL         = (1 << 7)
+	ITEM_REPORT_XATTR       = (1 << 8)
+	ITEM_REPORT_CRTIME      = (1 << 10)
+	ITEM_BASIS_TYPE_FOLLOWS = (1 << 11)
+	ITEM_XNAME_FOLLOWS      = (1 << 12)
+	ITEM_IS_NEW             = (1 << 13)
+	ITEM_LOCAL_CHANGE       = (1 << 14)
+	ITEM_TRANSFER           = (1 << 15)
+	// These are outside the range of the transmitted flags.
+	ITEM_MISSING_DATA = (1 << 16) // used by log_formatted()
+	ITEM_DELETED      = (1 << 17) // used by log_formatted()
+	ITEM_MATCHED      = (1 << 18) // used by itemize()
+
+	SIGNIFICANT_ITEM_FLAGS = ^(ITEM_BASIS_TYPE_FOLLOWS | ITEM_XNAME_FOLLOWS | ITEM_LOCAL_CHANGE)
+)
+
 // ProtocolVersion defines the currently implemented rsync protocol
 // version. Protocol version 27 seems to be the safest bet for wide
 // compatibility: version 27 was introduced by rsync 2.6.0 (released 2004), and
@@ -0,0 +1,148 @@
+package rsync
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// Code is an rsync exit code, see rsync/errcode.h.
+type Code int
+
+const (
+	RERR_OK          Code = 0
+	RERR_SYNTAX      Code = 1  // syntax or usage error
+	RERR_PROTOCOL    Code = 2  // protocol incompatibility
+	RERR_FILESELECT  Code = 3  // errors selecting input/output files, dirs
+	RERR_UNSUPPORTED Code = 4  // requested action not supported
+	RERR_STARTCLIENT Code = 5  // error starting client-server protocol
+	RERR_SOCKETIO    Code = 10 // error in socket IO
+	RERR_FILEIO      Code = 11 // error in file IO
+	RERR_STREAMIO    Code = 12 // error in rsync protocol data stream
+	RERR_MESSAGEIO   Code = 13 // errors with program diagnostics
+	RERR_IPC         Code = 14 // error in IPC code
+	RERR_CRASHED     Code = 15 // sibling crashed
+	RERR_TERMINATED  Code = 16 // sibling terminated abnormally
+	RERR_SIGNAL      Code = 20 // status returned when sent SIGUSR1, SIGINT
+	RERR_WAITCHILD   Code = 21 // some error returned by waitpid()
+	RERR_MALLOC      Code = 22 // error allocating core memory buffers
+	RERR_PARTIAL     Code = 23 // partial transfer
+	RERR_VANISHED    Code = 24 // file(s) vanished on sender side
+	RERR_DEL_LIMIT   Code = 25 // skipped some deletes due to --max-delete
+	RERR_TIMEOUT     Code = 30 // timeout in data send/receive
+	RERR_CONTIMEOUT  Code = 35 // timeout waiting for daemon connection
+)
+
+// rsync/log.c:rerr_names
+var codeNames = map[Code]string{
+	RERR_SYNTAX:      "syntax or usage error",
+	RERR_PROTOCOL:    "protocol incompatibility",
+	RERR_FILESELECT:  "errors selecting input/output files, dirs",
+	RERR_UNSUPPORTED: "requested action not supported",
+	RERR_STARTCLIENT: "error starting client-server protocol",
+	RERR_SOCKETIO:    "error in socket IO",
+	RERR_FILEIO:      "error in file IO",
+	RERR_STREAMIO:    "error in rsync protocol data stream",
+	RERR_MESSAGEIO:   "errors with program diagnostics",
+	RERR_IPC:         "error in IPC code",
+	RERR_CRASHED:     "sibling process crashed",
+	RERR_TERMINATED:  "sibling process terminated abnormally",
+	RERR_SIGNAL:      "received SIGINT, SIGTERM, or SIGHUP",
+	RERR_WAITCHILD:   "waitpid() failed",
+	RERR_MALLOC:      "error allocating core memory buffers",
+	RERR_PARTIAL:     "some files/attrs were not transferred (see previous errors)",
+	RERR_VANISHED:    "some files vanished before they could be transferred",
+	RERR_DEL_LIMIT:   "the --max-delete limit stopped deletions",
+	RERR_TIMEOUT:     "timeout in data send/receive",
+	RERR_CONTIMEOUT:  "timeout waiting for daemon connection",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("unexplained error (code %d)", int(c))
+}
+
+// Error is an error which terminates rsync with exit code Code.
+type Error struct {
+	Code Code
+	Err  error
+}
+
+// Sentinel errors for use with errors.Is, e.g.
+// errors.Is(err, rsync.ErrProtocol).
+var (
+	ErrSyntax      = &Error{Code: RERR_SYNTAX}
+	ErrProtocol    = &Error{Code: RERR_PROTOCOL}
+	ErrFileSelect  = &Error{Code: RERR_FILESELECT}
+	ErrUnsupported = &Error{Code: RERR_UNSUPPORTED}
+	ErrFileIO      = &Error{Code: RERR_FILEIO}
+	ErrStreamIO    = &Error{Code: RERR_STREAMIO}
+	ErrPartial     = &Error{Code: RERR_PARTIAL}
+	ErrVanished    = &Error{Code: RERR_VANISHED}
+	ErrDelLimit    = &Error{Code: RERR_DEL_LIMIT}
+	ErrTimeout     = &Error{Code: RERR_TIMEOUT}
+)
+
+// NewError returns err annotated with exit code code.
+func NewError(code Code, err error) error {
+	return &Error{Code: code, Err: err}
+}
+
+// Errorf formats an error (like fmt.Errorf) with exit code code.
+func Errorf(code Code, format string, a ...any) error {
+	return &Error{Code: code, Err: fmt.Errorf(format, a...)}
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Code.String()
+	}
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is reports whether target is the sentinel error of e’s exit code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Err == nil && t.Code == e.Code
+}
+
+// ExitCode returns e.Code as an int.
+func (e *Error) ExitCode() int { return int(e.Code) }
+
+// ExitCode returns the rsync exit code for err, which is the status an rsync
+// client expects (e.g. in an SSH exit-status request):
+//
+//   - 0 for a nil error,
+//   - the code of any error in err’s chain which has an ExitCode() int method
+//     (such as *Error),
+//   - RERR_TIMEOUT for I/O timeouts (os.ErrDeadlineExceeded, which
+//     *rsyncwire.TimeoutError wraps),
+//   - RERR_STREAMIO for an unexpected end of the connection,
+//   - RERR_FILEIO for file system errors,
+//   - RERR_PARTIAL otherwise.
+func ExitCode(err error) int {
+	if err == nil {
+		return int(RERR_OK)
+	}
+	var coder interface{ ExitCode() int }
+	if errors.As(err, &coder) {
+		return coder.ExitCode()
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return int(RERR_TIMEOUT)
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return int(RERR_STREAMIO)
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return int(RERR_FILEIO)
+	}
+	return int(RERR_PARTIAL)
+}
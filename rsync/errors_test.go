@@ -0,0 +1,37 @@
+package rsync_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+)
+
+func TestExitCode(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want int
+	}{
+		{nil, 0},
+		{rsync.Errorf(rsync.RERR_PROTOCOL, "invalid block length %d", -1), 2},
+		{fmt.Errorf("receiver: %w", rsync.ErrDelLimit), 25},
+		{fmt.Errorf("reading: %w", io.ErrUnexpectedEOF), 12},
+		{&os.PathError{Op: "open", Path: "x", Err: os.ErrPermission}, 11},
+		{errors.New("something else"), 23},
+	} {
+		if got := rsync.ExitCode(tt.err); got != tt.want {
+			t.Errorf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+
+	err := rsync.Errorf(rsync.RERR_PROTOCOL, "overflow")
+	if !errors.Is(err, rsync.ErrProtocol) {
+		t.Errorf("errors.Is(%v, ErrProtocol) = false, want true", err)
+	}
+	if errors.Is(err, rsync.ErrSyntax) {
+		t.Errorf("errors.Is(%v, ErrSyntax) = true, want false", err)
+	}
+}
@@ -1,8 +1,6 @@
 package rsync
 
 import (
-	"fmt"
-
 	"github.com/picosh/go-rsync-receiver/rsyncwire"
 )
 
@@ -45,7 +43,7 @@ func (sh *SumHead) ReadFrom(c *rsyncwire.Conn) error {
 		return err
 	}
 	if sh.ChecksumCount < 0 {
-		return fmt.Errorf("invalid checksum count %d", sh.ChecksumCount)
+		return Errorf(RERR_PROTOCOL, "invalid checksum count %d", sh.ChecksumCount)
 	}
 
 	sh.BlockLength, err = c.ReadInt32()
@@ -53,7 +51,7 @@ func (sh *SumHead) ReadFrom(c *rsyncwire.Conn) error {
 		return err
 	}
 	if sh.BlockLength < 0 || sh.BlockLength > maxBlockLen {
-		return fmt.Errorf("invalid block length %d", sh.BlockLength)
+		return Errorf(RERR_PROTOCOL, "invalid block length %d", sh.BlockLength)
 	}
 
 	sh.ChecksumLength, err = c.ReadInt32()
@@ -62,7 +60,7 @@ func (sh *SumHead) ReadFrom(c *rsyncwire.Conn) error {
 	}
 	// TODO(protocol>=27): update max sh.ChecksumLength check
 	if sh.ChecksumLength < 0 || sh.ChecksumLength > 16 {
-		return fmt.Errorf("invalid checksum length %d", sh.ChecksumLength)
+		return Errorf(RERR_PROTOCOL, "invalid checksum length %d", sh.ChecksumLength)
 	}
 
 	sh.RemainderLength, err = c.ReadInt32()
@@ -70,7 +68,7 @@ func (sh *SumHead) ReadFrom(c *rsyncwire.Conn) error {
 		return err
 	}
 	if sh.RemainderLength < 0 || sh.RemainderLength > sh.BlockLength {
-		return fmt.Errorf("invalid remainder length %d", sh.RemainderLength)
+		return Errorf(RERR_PROTOCOL, "invalid remainder length %d", sh.RemainderLength)
 	}
 
 	return nil
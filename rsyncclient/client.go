@@ -0,0 +1,276 @@
+// Package rsyncclient implements the client side of the rsync protocol: it
+// drives a remote rsync --server (started e.g. via ssh) to push files to it
+// or to pull files from it.
+package rsyncclient
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/rsyncopts"
+	"github.com/picosh/go-rsync-receiver/rsyncreceiver"
+	"github.com/picosh/go-rsync-receiver/rsyncsender"
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
+	"github.com/picosh/go-rsync-receiver/rsyncwire"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+// rsync/rsync.h:IO_BUFFER_SIZE
+const ioBufferSize = 32 * 1024
+
+// rsync/main.c:client_run
+//
+// Run transfers files with the rsync --server on the other end of conn,
+// which must have been started with ServerArgs(opts, …).
+//
+// If opts.Sender() is true, the files at paths are read from filesystem and
+// pushed to the server. Otherwise, the files which the server sends are
+// stored in filesystem (paths are not used, the server was given its paths
+// on its command line).
+//
+// When conn is a subprocess (see Command), an error returned by Run is often
+// the consequence of the server exiting, which the error returned by closing
+// conn describes more accurately.
+func Run(logger *slog.Logger, opts *rsyncopts.Options, conn io.ReadWriter, filesystem utils.FS, paths []string, options ...Option) (*rsyncstats.TransferStats, error) {
+	co := clientOptions{
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+	for _, opt := range options {
+		opt(&co)
+	}
+
+	crd, cwr := rsyncwire.CounterPair(conn, conn)
+	c := &rsyncwire.Conn{
+		Reader: crd,
+		Writer: cwr,
+	}
+
+	connectTimeout := time.Duration(opts.ConnectTimeoutSeconds()) * time.Second
+	remoteProtocol, seed, err := handshake(c, conn, connectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("remote protocol", "protocol", remoteProtocol, "seed", seed)
+
+	// rsync/io.c:check_timeout
+	if timeout := time.Duration(opts.IOTimeoutSeconds()) * time.Second; timeout > 0 {
+		tconn := rsyncwire.WithTimeout(conn, timeout)
+		crd.R = tconn
+		cwr.W = tconn
+	}
+
+	// rsync/io.c:sleep_for_bwlimit
+	if co.limiter != nil {
+		rsyncwire.LimitPair(co.limiter, crd, cwr)
+	} else if bwlimit := opts.BwLimit(); bwlimit > 0 {
+		cwr.W = rsyncwire.NewLimiter(int64(bwlimit) * 1024).Writer(cwr.W)
+	}
+
+	// The server multiplexes its output, but reads our output unmultiplexed.
+	cl := &client{
+		stdout: co.stdout,
+		stderr: co.stderr,
+	}
+	c.Reader = &rsyncwire.MultiplexReader{
+		Reader:  c.Reader,
+		Handler: cl.handleMsg,
+	}
+	bw := bufio.NewWriterSize(c.Writer, ioBufferSize)
+	c.Writer = bw
+
+	var stats *rsyncstats.TransferStats
+	if opts.Sender() {
+		// The sender reads and writes from the same goroutine, so it can
+		// flush its output whenever it needs to wait for the server.
+		c.Reader = &rsyncwire.FlushingReader{R: c.Reader, W: bw}
+		st := &rsyncsender.Transfer{
+			Opts:  opts,
+			Conn:  c,
+			Seed:  seed,
+			Files: filesystem,
+
+			RemoteProtocol: remoteProtocol,
+			Observer:       co.observer,
+			LogSink:        co.logSink,
+			LogFormat:      co.logFormat,
+			Stdout:         co.stdout,
+
+			Logger: logger,
+		}
+		stats, err = push(st, crd, cwr, paths)
+	} else {
+		rt := &rsyncreceiver.Transfer{
+			Opts: rsyncreceiver.NewTransferOpts(opts),
+			Dest: "/",
+			Env: rsyncreceiver.Osenv{
+				Stdout: co.stdout,
+				Stderr: co.stderr,
+			},
+			Conn:  c,
+			Seed:  seed,
+			Files: filesystem,
+
+			RemoteProtocol: remoteProtocol,
+			Observer:       co.observer,
+			LogSink:        co.logSink,
+			LogFormat:      co.logFormat,
+
+			Logger: logger,
+		}
+		if opts.ListOnly() {
+			rt.Dest = ""
+		}
+		stats, err = pull(rt, crd, cwr)
+	}
+	if stats != nil {
+		stats.Read = crd.BytesRead
+		stats.Written = cwr.BytesWritten
+	}
+	return stats, cl.result(err)
+}
+
+// rsync/compat.c:setup_protocol
+//
+// handshake performs the client side of the protocol version exchange and
+// reads the checksum seed. If timeout is non-zero, the server must respond
+// within timeout, otherwise RERR_CONTIMEOUT is returned.
+func handshake(c *rsyncwire.Conn, conn io.ReadWriter, timeout time.Duration) (remoteProtocol, seed int32, err error) {
+	var timedOut atomic.Bool
+	if timeout > 0 {
+		if d, ok := conn.(interface{ SetDeadline(time.Time) error }); ok {
+			if err := d.SetDeadline(time.Now().Add(timeout)); err == nil {
+				defer d.SetDeadline(time.Time{})
+			}
+		} else if cl, ok := conn.(io.Closer); ok {
+			t := time.AfterFunc(timeout, func() {
+				timedOut.Store(true)
+				cl.Close()
+			})
+			defer t.Stop()
+		}
+	}
+	defer func() {
+		if err != nil && (timedOut.Load() || errors.Is(err, os.ErrDeadlineExceeded)) {
+			err = rsync.Errorf(rsync.RERR_CONTIMEOUT, "timed out after %v waiting for the server", timeout)
+		}
+	}()
+
+	if err := c.WriteInt32(rsync.ProtocolVersion); err != nil {
+		return 0, 0, err
+	}
+	remoteProtocol, err = c.ReadInt32()
+	if err != nil {
+		return 0, 0, err
+	}
+	if remoteProtocol < rsync.ProtocolVersion {
+		return 0, 0, rsync.Errorf(rsync.RERR_PROTOCOL, "protocol version mismatch: server speaks %d, we need at least %d", remoteProtocol, rsync.ProtocolVersion)
+	}
+	seed, err = c.ReadInt32()
+	if err != nil {
+		return 0, 0, err
+	}
+	return remoteProtocol, seed, nil
+}
+
+// push sends paths to the server, which runs as receiver.
+func push(st *rsyncsender.Transfer, crd *rsyncwire.CountingReader, cwr *rsyncwire.CountingWriter, paths []string) (*rsyncstats.TransferStats, error) {
+	// Filter options are not yet supported, so we only ever send an empty
+	// filter list. The server only reads it when deleting.
+	filters := &rsyncsender.FilterRuleList{}
+	if st.Opts.DeleteMode() && !st.Opts.DeleteExcluded() {
+		if err := rsyncsender.SendFilterList(st.Conn, filters); err != nil {
+			return nil, err
+		}
+	}
+	return st.DoClient(crd, cwr, paths, filters)
+}
+
+// pull receives the files which the server, running as sender, sends.
+func pull(rt *rsyncreceiver.Transfer, crd *rsyncwire.CountingReader, cwr *rsyncwire.CountingWriter) (*rsyncstats.TransferStats, error) {
+	// rsync/exclude.c:send_filter_list (a sending server always reads it)
+	if err := rsyncsender.SendFilterList(rt.Conn, nil); err != nil {
+		return nil, err
+	}
+	if err := rt.Conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	rt.Logger.Debug("receiving file list")
+	flistStart := crd.BytesRead
+	fileList, err := rt.ReceiveFileList()
+	if err != nil {
+		return nil, err
+	}
+	flistSize := crd.BytesRead - flistStart
+
+	stats, err := rt.Do(rt.Conn, fileList, false)
+	if stats != nil {
+		stats.FileListSize = flistSize
+	}
+	return stats, err
+}
+
+// client displays the out-of-band messages of the server.
+type client struct {
+	stdout io.Writer
+	stderr io.Writer
+
+	// errors counts the error messages of the server, ioError accumulates
+	// the rsync.IOERR_* flags it reported.
+	errors  int
+	ioError int32
+}
+
+// rsync/io.c:read_a_msg
+func (cl *client) handleMsg(tag uint8, payload []byte) error {
+	switch tag {
+	case rsyncwire.MsgInfo, rsyncwire.MsgClient:
+		_, err := cl.stdout.Write(payload)
+		return err
+	case rsyncwire.MsgError, rsyncwire.MsgWarning:
+		if tag == rsyncwire.MsgError {
+			cl.errors++
+		}
+		_, err := cl.stderr.Write(payload)
+		return err
+	case rsyncwire.MsgDeleted:
+		name, isDir := rsyncwire.DecodeDeleted(payload)
+		if isDir {
+			name += "/"
+		}
+		_, err := fmt.Fprintf(cl.stdout, "deleting %s\n", name)
+		return err
+	case rsyncwire.MsgIOError:
+		flags, err := rsyncwire.DecodeMsgInt(payload)
+		if err != nil {
+			return rsync.NewError(rsync.RERR_STREAMIO, err)
+		}
+		cl.ioError |= flags
+		return nil
+	case rsyncwire.MsgLog, rsyncwire.MsgNoop, rsyncwire.MsgSuccess, rsyncwire.MsgNoSend:
+		return nil
+	}
+	return rsync.Errorf(rsync.RERR_STREAMIO, "unexpected message tag %d from server", tag)
+}
+
+// result returns err, or an error describing the problems the server
+// reported during an otherwise successful transfer.
+func (cl *client) result(err error) error {
+	switch {
+	case err != nil:
+		return err
+	case cl.ioError&rsync.IOERR_VANISHED != 0 && cl.ioError&^rsync.IOERR_VANISHED == 0 && cl.errors == 0:
+		return rsync.ErrVanished
+	case cl.ioError != 0 || cl.errors > 0:
+		return rsync.ErrPartial
+	}
+	return nil
+}
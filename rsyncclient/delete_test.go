@@ -0,0 +1,81 @@
+package rsyncclient_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/picosh/go-rsync-receiver/localfs"
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+func TestRunLocalDelete(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"keep.txt":     "keep",
+		"sub/keep.txt": "keep",
+	})
+	existing := map[string]string{
+		"keep.txt":           "keep",
+		"sub/keep.txt":       "keep",
+		"extra.txt":          "extra",
+		"sub/extra.txt":      "extra",
+		"gone/deep/file.txt": "gone",
+	}
+
+	for _, fsys := range []struct {
+		name string
+		new  func(dir string) utils.FS
+	}{
+		{"Deleter", func(dir string) utils.FS { return localfs.New(dir) }},
+		// Hide the optional interfaces, so that deletions use Remove.
+		{"Remove", func(dir string) utils.FS { return struct{ utils.FS }{localfs.New(dir)} }},
+	} {
+		// The client does not send filter rules yet, so --delete-excluded
+		// only implies --delete.
+		for _, mode := range []string{"--delete-before", "--delete-during", "--delete-after", "--delete-delay", "--delete-excluded"} {
+			t.Run(fsys.name+mode, func(t *testing.T) {
+				dst := t.TempDir()
+				writeFiles(t, dst, existing)
+				_, out, err := runLocalFS(t, "-rv "+mode, src, fsys.new(dst))
+				if err != nil {
+					t.Fatalf("%v (output: %s)", err, out)
+				}
+				checkFiles(t, dst, map[string]string{
+					"keep.txt":     "keep",
+					"sub/keep.txt": "keep",
+				})
+				// Protocol 27 servers log deletions themselves, so the
+				// client’s statistics do not count them.
+				for _, name := range []string{"extra.txt", "sub/extra.txt", "gone/deep/file.txt", "gone/deep", "gone"} {
+					if !strings.Contains(out, "deleting "+name+"\n") {
+						t.Errorf("output does not mention deleting %s: %q", name, out)
+					}
+				}
+			})
+		}
+	}
+
+	t.Run("MaxDelete", func(t *testing.T) {
+		dst := t.TempDir()
+		writeFiles(t, dst, existing)
+		_, out, err := runLocalFS(t, "-rv --delete --max-delete=2", src, localfs.New(dst))
+		if got, want := rsync.ExitCode(err), int(rsync.RERR_DEL_LIMIT); got != want {
+			t.Errorf("exit code %d (%v), want %d", got, err, want)
+		}
+		if got, want := strings.Count(out, "deleting "), 2; got != want {
+			t.Errorf("deleted %d files, want %d (output: %q)", got, want, out)
+		}
+		remaining := 0
+		for name := range existing {
+			if _, err := os.Stat(filepath.Join(dst, name)); err == nil {
+				remaining++
+			}
+		}
+		if got, want := remaining, len(existing)-2; got != want {
+			t.Errorf("%d files remain, want %d", got, want)
+		}
+	})
+}
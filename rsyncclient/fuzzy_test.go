@@ -0,0 +1,62 @@
+package rsyncclient_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/picosh/go-rsync-receiver/localfs"
+	"github.com/picosh/go-rsync-receiver/rsync"
+)
+
+func TestRunLocalFuzzy(t *testing.T) {
+	var b strings.Builder
+	for i := 0; b.Len() < 256*1024; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	data := b.String()
+
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{"sub/data-v2.bin": data})
+
+	for _, tt := range []struct {
+		args     string
+		existing map[string]string
+		fuzzy    bool // whether the existing data-v1.bin serves as basis
+	}{
+		{"-r", map[string]string{"sub/data-v1.bin": data + "old\n"}, false},
+		{"-ry", map[string]string{"sub/data-v1.bin": data + "old\n"}, true},
+		// The most similar name wins over a file with a different suffix.
+		{"-ry", map[string]string{
+			"sub/data-v1.bin":  data + "old\n",
+			"sub/data-v2.txt":  "unrelated\n",
+			"sub/unrelated.gz": "unrelated\n",
+		}, true},
+		// Only regular files in the same directory are candidates.
+		{"-ry", map[string]string{"data-v1.bin": data + "old\n"}, false},
+		// -y does not look in basis directories, -yy does.
+		{"-ry --compare-dest=base", map[string]string{"base/sub/data-v1.bin": data + "old\n"}, false},
+		{"-ryy --compare-dest=base", map[string]string{"base/sub/data-v1.bin": data + "old\n"}, true},
+		{"-ryy --link-dest=base", map[string]string{"base/sub/data-v1.bin": data + "old\n"}, true},
+	} {
+		dst := t.TempDir()
+		writeFiles(t, dst, tt.existing)
+		stats, _ := runLocal(t, tt.args, src, dst)
+		want := make(map[string]string)
+		for name, content := range tt.existing {
+			want[name] = content
+		}
+		want["sub/data-v2.bin"] = data
+		checkFiles(t, dst, want)
+		if got := stats.MatchedData > int64(len(data))/2; got != tt.fuzzy {
+			t.Errorf("%s with %v: matched %d of %d bytes, want fuzzy basis %v", tt.args, tt.existing, stats.MatchedData, len(data), tt.fuzzy)
+		}
+	}
+
+	// Basis directories cannot reach outside of the destination.
+	dst := t.TempDir()
+	_, out, err := runLocalFS(t, "-ryy --link-dest=../base", src, localfs.New(dst))
+	if got, want := rsync.ExitCode(err), int(rsync.RERR_SYNTAX); got != want {
+		t.Errorf("--link-dest=../base: exit code %d (%v, output: %s), want %d", got, err, out, want)
+	}
+}
@@ -0,0 +1,53 @@
+package rsyncclient
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+
+	"github.com/picosh/go-rsync-receiver/rsyncopts"
+	"github.com/picosh/go-rsync-receiver/rsyncreceiver"
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+// rsync/main.c:local_child
+//
+// RunLocal copies paths from src to dst, like rsync does when neither the
+// source nor the destination is remote: the receiver runs as a server in a
+// separate goroutine, connected to us (the sender) by an in-memory pipe.
+// src and dst can be any file systems, e.g. a local directory and an object
+// store.
+//
+// options apply to the sending side, which reports on the transfer.
+func RunLocal(logger *slog.Logger, opts *rsyncopts.Options, src utils.FS, paths []string, dst utils.FS, options ...Option) (*rsyncstats.TransferStats, error) {
+	opts.SetSender()
+	opts.SetLocalServer()
+
+	// Like rsync’s local_child, the receiver uses the same options as we do,
+	// which we obtain by parsing the arguments of a remote server.
+	pc, err := rsyncopts.ParseArguments(append(rsyncopts.BuildServerArgs(opts), "."), false)
+	if err != nil {
+		return nil, err
+	}
+	serverOpts := pc.Options
+	serverOpts.SetLocalServer()
+
+	clientConn, serverConn := net.Pipe()
+	serverErr := make(chan error, 1)
+	go func() {
+		err := rsyncreceiver.ClientRun(logger, serverOpts, serverConn, dst, nil, true)
+		// Unblock the sender if the receiver failed.
+		serverConn.Close()
+		serverErr <- err
+	}()
+
+	stats, err := Run(logger, opts, clientConn, src, paths, options...)
+	clientConn.Close()
+	if rerr := <-serverErr; rerr != nil && (err == nil || errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe)) {
+		// The receiver’s error explains why the connection broke.
+		return stats, rerr
+	}
+	return stats, err
+}
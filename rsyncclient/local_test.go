@@ -0,0 +1,223 @@
+package rsyncclient_test
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/picosh/go-rsync-receiver/localfs"
+	"github.com/picosh/go-rsync-receiver/rsyncclient"
+	"github.com/picosh/go-rsync-receiver/rsyncopts"
+	"github.com/picosh/go-rsync-receiver/rsyncsender"
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+var mtime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkFiles(t *testing.T, dir string, want map[string]string) {
+	t.Helper()
+	got := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		got[filepath.ToSlash(rel)] = string(b)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("%s: got %d bytes, want %d bytes", name, len(got[name]), len(content))
+		}
+	}
+	for name := range got {
+		if _, ok := want[name]; !ok {
+			t.Errorf("unexpected file %s", name)
+		}
+	}
+}
+
+// runLocal runs rsync args src/ dst/, returning the statistics and output.
+func runLocal(t *testing.T, args string, src, dst string) (*rsyncstats.TransferStats, string) {
+	t.Helper()
+	stats, out, err := runLocalFS(t, args, src, localfs.New(dst))
+	if err != nil {
+		t.Fatalf("RunLocal(%s): %v (output: %s)", args, err, out)
+	}
+	return stats, out
+}
+
+// runLocalFS is like runLocal, but copies to dst and returns errors.
+func runLocalFS(t *testing.T, args string, src string, dst utils.FS, options ...rsyncclient.Option) (*rsyncstats.TransferStats, string, error) {
+	t.Helper()
+	pc, err := rsyncopts.ParseArguments(strings.Fields(args), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	options = append(options, rsyncclient.WithOutput(&stdout, &stdout))
+	stats, err := rsyncclient.RunLocal(logger, pc.Options, localfs.New(src), []string{"."}, dst, options...)
+	return stats, stdout.String(), err
+}
+
+func TestRunLocal(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+	big := strings.Repeat("0123456789abcdef", 64*1024)
+	files := map[string]string{
+		"hello.txt":     "hello world\n",
+		"sub/big.bin":   big,
+		"sub/empty.txt": "",
+	}
+	writeFiles(t, src, files)
+	if err := os.Symlink("hello.txt", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, out := runLocal(t, "-av", src, dst)
+	checkFiles(t, dst, files)
+	if got, want := stats.TransferredFiles, int64(3); got != want {
+		t.Errorf("TransferredFiles = %d, want %d", got, want)
+	}
+	if !strings.Contains(out, "sub/big.bin\n") {
+		t.Errorf("-v output does not mention sub/big.bin: %q", out)
+	}
+	if fi, err := os.Stat(filepath.Join(dst, "hello.txt")); err != nil {
+		t.Fatal(err)
+	} else if !fi.ModTime().Equal(mtime) {
+		t.Errorf("hello.txt: ModTime = %v, want %v", fi.ModTime(), mtime)
+	}
+
+	t.Run("Unchanged", func(t *testing.T) {
+		stats, _ := runLocal(t, "-a", src, dst)
+		if stats.TransferredFiles != 0 {
+			t.Errorf("TransferredFiles = %d, want 0", stats.TransferredFiles)
+		}
+	})
+
+	t.Run("Delta", func(t *testing.T) {
+		files["sub/big.bin"] = big[:1000] + "changed" + big[1000:]
+		writeFiles(t, src, files)
+		stats, _ := runLocal(t, "-a", src, dst)
+		checkFiles(t, dst, files)
+		if stats.MatchedData == 0 || stats.LiteralData >= int64(len(big)) {
+			t.Errorf("file was not transferred incrementally: matched %d, literal %d bytes", stats.MatchedData, stats.LiteralData)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		writeFiles(t, dst, map[string]string{"extraneous/file.txt": "remove me"})
+		_, out := runLocal(t, "-av --delete", src, dst)
+		checkFiles(t, dst, files)
+		if !strings.Contains(out, "deleting extraneous/file.txt") {
+			t.Errorf("-v output does not mention the deletion: %q", out)
+		}
+	})
+}
+
+func TestRunLocalSymlinkEscape(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+	outside := t.TempDir()
+	// An earlier transfer left a symbolic link pointing outside of dst, which
+	// the directory of the same name in src must replace, not follow.
+	if err := os.Symlink(outside, filepath.Join(dst, "evil")); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, src, map[string]string{"evil/x": "x"})
+	pc, err := rsyncopts.ParseArguments([]string{"-r"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	rsyncclient.RunLocal(logger, pc.Options, localfs.New(src), []string{"."}, localfs.New(dst),
+		rsyncclient.WithOutput(io.Discard, io.Discard))
+	if entries, err := os.ReadDir(outside); err != nil || len(entries) > 0 {
+		t.Errorf("files were created outside of the destination: %v, %v", entries, err)
+	}
+}
+
+func TestRunLocalSizeLimits(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"empty.txt": "",
+		"small.txt": "small",
+		"large.bin": strings.Repeat("x", 2048),
+	})
+	_, out := runLocal(t, "-rv --max-size=1K --min-size=1", src, dst)
+	checkFiles(t, dst, map[string]string{"small.txt": "small"})
+	if !strings.Contains(out, "large.bin is over max-size") || !strings.Contains(out, "empty.txt is under min-size") {
+		t.Errorf("-v output does not mention skipped files: %q", out)
+	}
+}
+
+func TestRunPull(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+	files := map[string]string{
+		"a.txt":     "a\n",
+		"dir/b.txt": strings.Repeat("b", 100000),
+	}
+	writeFiles(t, src, files)
+
+	pc, err := rsyncopts.ParseArguments([]string{"-a"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := pc.Options
+	spc, err := rsyncopts.ParseArguments(rsyncclient.ServerArgs(opts, []string{"."}), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	clientConn, serverConn := net.Pipe()
+	serverErr := make(chan error, 1)
+	go func() {
+		defer serverConn.Close()
+		serverErr <- rsyncsender.ClientRun(logger, spc.Options, serverConn, localfs.New(src), spc.RemainingArgs[1:], true)
+	}()
+	stats, err := rsyncclient.Run(logger, opts, clientConn, localfs.New(dst), nil, rsyncclient.WithOutput(io.Discard, io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-serverErr; err != nil {
+		t.Fatalf("server: %v", err)
+	}
+	checkFiles(t, dst, files)
+	if got, want := stats.Size, int64(100002); got != want {
+		t.Errorf("Size = %d, want %d", got, want)
+	}
+}
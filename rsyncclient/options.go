@@ -0,0 +1,51 @@
+package rsyncclient
+
+import (
+	"io"
+
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
+	"github.com/picosh/go-rsync-receiver/rsyncwire"
+)
+
+// Option customizes a transfer started by Run.
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	limiter  *rsyncwire.Limiter
+	observer rsyncstats.Observer
+
+	logSink   io.Writer
+	logFormat string
+
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// WithLimiter limits the bandwidth of the transfer in both directions with l,
+// overriding the --bwlimit option.
+func WithLimiter(l *rsyncwire.Limiter) Option {
+	return func(o *clientOptions) { o.limiter = l }
+}
+
+// WithObserver notifies o about the progress of the transfer.
+func WithObserver(o rsyncstats.Observer) Option {
+	return func(co *clientOptions) { co.observer = o }
+}
+
+// WithLogSink writes a line in format (rsynclog.DefaultFormat if empty) to w
+// for each file of the transfer, like rsync’s --log-file.
+func WithLogSink(w io.Writer, format string) Option {
+	return func(co *clientOptions) {
+		co.logSink = w
+		co.logFormat = format
+	}
+}
+
+// WithOutput sets where the output of the transfer (--out-format lines and
+// messages of the server) is written, instead of os.Stdout and os.Stderr.
+func WithOutput(stdout, stderr io.Writer) Option {
+	return func(co *clientOptions) {
+		co.stdout = stdout
+		co.stderr = stderr
+	}
+}
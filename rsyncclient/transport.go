@@ -0,0 +1,162 @@
+package rsyncclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/rsyncopts"
+)
+
+// rsync/main.c:do_cmd
+//
+// ServerCommand returns the command which starts rsync --server for a
+// transfer of the server’s paths: via the remote shell (--rsh, $RSYNC_RSH or
+// ssh) on host, or as a local subprocess if host is empty. host may be of the
+// form user@host.
+//
+// The command’s standard error is left unset; set cmd.Stderr to display the
+// server’s diagnostics.
+func ServerCommand(opts *rsyncopts.Options, host string, paths []string) (*exec.Cmd, error) {
+	if host == "" {
+		return exec.Command(opts.RsyncPath(), ServerArgs(opts, paths)...), nil
+	}
+
+	shell := opts.ShellCommand()
+	if shell == "" {
+		shell = os.Getenv("RSYNC_RSH")
+	}
+	if shell == "" {
+		shell = "ssh"
+	}
+	argv, err := splitShellCommand(shell)
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Base(argv[0]) == "ssh" && opts.ConnectTimeoutSeconds() > 0 {
+		argv = append(argv, "-o", "ConnectTimeout="+strconv.Itoa(opts.ConnectTimeoutSeconds()))
+	}
+	if user, h, ok := strings.Cut(host, "@"); ok {
+		argv = append(argv, "-l", user)
+		host = h
+	}
+	argv = append(argv, host, opts.RsyncPath())
+	// The remote shell passes the command to a shell on the remote host, so
+	// file names need to be quoted.
+	argv = append(argv, ServerArgs(opts, quoteArgs(paths))...)
+	return exec.Command(argv[0], argv[1:]...), nil
+}
+
+// ServerArgs returns the arguments for rsync --server which make the server
+// the counterpart of a client using opts (see rsyncopts.BuildServerArgs),
+// followed by the server’s paths.
+func ServerArgs(opts *rsyncopts.Options, paths []string) []string {
+	args := append(rsyncopts.BuildServerArgs(opts), ".")
+	return append(args, paths...)
+}
+
+// Command starts cmd (e.g. from ServerCommand) and returns a connection to its
+// standard input and output, for use with Run.
+//
+// Closing the connection closes the command’s standard input and waits for
+// the command to exit. If it exited with a non-zero status, Close returns an
+// *rsync.Error carrying the exit status.
+func Command(cmd *exec.Cmd) (io.ReadWriteCloser, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, rsync.NewError(rsync.RERR_STARTCLIENT, err)
+	}
+	return &cmdConn{cmd: cmd, stdin: stdin, stdout: stdout}, nil
+}
+
+type cmdConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.Reader
+}
+
+func (c *cmdConn) Read(p []byte) (int, error)  { return c.stdout.Read(p) }
+func (c *cmdConn) Write(p []byte) (int, error) { return c.stdin.Write(p) }
+
+// rsync/main.c:wait_process_with_flush
+func (c *cmdConn) Close() error {
+	c.stdin.Close()
+	err := c.cmd.Wait()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	code := exitErr.ExitCode()
+	if code < 0 {
+		return rsync.NewError(rsync.RERR_TERMINATED, fmt.Errorf("%s: %v", c.cmd.Path, err))
+	}
+	return rsync.NewError(rsync.Code(code), fmt.Errorf("%s exited with code %d", c.cmd.Path, code))
+}
+
+// splitShellCommand splits cmd into words at whitespace, honoring single and
+// double quotes like rsync does for --rsh.
+func splitShellCommand(cmd string) ([]string, error) {
+	var (
+		words []string
+		word  strings.Builder
+		quote byte
+		in    bool
+	)
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteByte(c)
+		case c == '\'' || c == '"':
+			quote = c
+			in = true
+		case c == ' ' || c == '\t':
+			if in {
+				words = append(words, word.String())
+				word.Reset()
+				in = false
+			}
+		default:
+			word.WriteByte(c)
+			in = true
+		}
+	}
+	if quote != 0 {
+		return nil, rsync.Errorf(rsync.RERR_SYNTAX, "missing trailing-%c in remote-shell command", quote)
+	}
+	if in {
+		words = append(words, word.String())
+	}
+	if len(words) == 0 {
+		return nil, rsync.Errorf(rsync.RERR_SYNTAX, "empty remote-shell command")
+	}
+	return words, nil
+}
+
+// quoteArgs quotes args for a POSIX shell, where needed.
+func quoteArgs(args []string) []string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./,:+@%=") == "" {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return quoted
+}
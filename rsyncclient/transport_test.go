@@ -0,0 +1,44 @@
+package rsyncclient_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/picosh/go-rsync-receiver/rsyncclient"
+	"github.com/picosh/go-rsync-receiver/rsyncopts"
+)
+
+func TestServerCommand(t *testing.T) {
+	pc, err := rsyncopts.ParseArguments([]string{"-av", "--delete", "--rsh=ssh -p 2222", "--contimeout=5"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := pc.Options
+
+	cmd, err := rsyncclient.ServerCommand(opts, "user@example.com", []string{"src dir/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"ssh", "-p", "2222",
+		"-o", "ConnectTimeout=5",
+		"-l", "user", "example.com",
+		"rsync", "--server", "--sender", "-vlogDtpr", "--delete-before", ".", "'src dir/'",
+	}
+	if !slices.Equal(cmd.Args, want) {
+		t.Errorf("ServerCommand() = %q, want %q", cmd.Args, want)
+	}
+
+	// The server must understand the arguments we generate.
+	spc, err := rsyncopts.ParseArguments(rsyncclient.ServerArgs(opts, []string{"src"}), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sopts := spc.Options
+	if !sopts.Server() || !sopts.Sender() || !sopts.Recurse() || !sopts.DeleteMode() || !sopts.PreserveMTimes() {
+		t.Errorf("server options do not match client options: %+v", sopts)
+	}
+	if got, want := spc.RemainingArgs, []string{".", "src"}; !slices.Equal(got, want) {
+		t.Errorf("RemainingArgs = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,88 @@
+package rsyncd
+
+import (
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/picosh/go-rsync-receiver/rsync"
+)
+
+// Config is the configuration of a server, usually loaded from a TOML file:
+//
+//	listen = ":873"
+//
+//	[[module]]
+//	name = "photos"
+//	path = "/srv/photos"
+//	comment = "holiday pictures"
+//	read_only = true
+//	filters = ["- *.tmp"]
+//
+//	[[module]]
+//	name = "uploads"
+//	backend = "s3"
+//	write_only = true
+//	users = ["alice", "bob"]
+//	max_file_size = "100M"
+//	max_total_size = "10G"
+//	max_files = 100000
+//	refuse_options = ["delete*", "D"]
+//	uid = 1000
+//	gid = 1000
+type Config struct {
+	// Listen is the address on which the daemon accepts connections.
+	Listen  string   `toml:"listen"`
+	Modules []Module `toml:"module"`
+}
+
+// LoadConfig reads and validates the configuration file path.
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, rsync.NewError(rsync.RERR_SYNTAX, err)
+	}
+	cfg, err := ParseConfig(string(b))
+	if err != nil {
+		return nil, rsync.Errorf(rsync.RERR_SYNTAX, "%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseConfig parses and validates a configuration in TOML format. Unknown
+// keys are rejected, as they are most likely misspelled settings.
+func ParseConfig(data string) (*Config, error) {
+	var cfg Config
+	md, err := toml.Decode(data, &cfg)
+	if err != nil {
+		return nil, rsync.NewError(rsync.RERR_SYNTAX, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return nil, rsync.Errorf(rsync.RERR_SYNTAX, "unknown configuration keys: %s", strings.Join(keys, ", "))
+	}
+	if err := cfg.Validate(nil); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate checks the configuration for errors such as duplicate module
+// names. If backends is not nil, modules must name one of them as Backend.
+func (c *Config) Validate(backends map[string]Backend) error {
+	seen := make(map[string]bool)
+	for i := range c.Modules {
+		mod := &c.Modules[i]
+		if err := mod.validate(backends); err != nil {
+			return rsync.NewError(rsync.RERR_SYNTAX, err)
+		}
+		if seen[mod.Name] {
+			return rsync.Errorf(rsync.RERR_SYNTAX, "duplicate module %s", mod.Name)
+		}
+		seen[mod.Name] = true
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package rsyncd_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/picosh/go-rsync-receiver/rsyncd"
+)
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := rsyncd.ParseConfig(`
+listen = "localhost:8730"
+
+[[module]]
+name = "photos"
+path = "/srv/photos"
+comment = "holiday pictures"
+read_only = true
+filters = ["- *.tmp", "- .cache/"]
+
+[[module]]
+name = "uploads"
+backend = "s3"
+write_only = true
+users = ["alice", "bob"]
+max_file_size = "100M"
+uid = 1000
+gid = 1000
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cfg.Listen, "localhost:8730"; got != want {
+		t.Errorf("Listen = %q, want %q", got, want)
+	}
+	if got, want := len(cfg.Modules), 2; got != want {
+		t.Fatalf("len(Modules) = %d, want %d", got, want)
+	}
+	uploads := cfg.Modules[1]
+	if uploads.Backend != "s3" || !uploads.WriteOnly || len(uploads.Users) != 2 || *uploads.UID != 1000 {
+		t.Errorf("uploads module = %+v", uploads)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	for _, tt := range []struct {
+		config string
+		want   string
+	}{
+		{"[[module]]\nname = \"a\"\npath = \"/a\"\nreadonly = true", "unknown configuration keys: module.readonly"},
+		{"[[module]]\nname = \"a\"", "one of path or backend must be set"},
+		{"[[module]]\nname = \"a\"\npath = \"/a\"\nbackend = \"s3\"", "mutually exclusive"},
+		{"[[module]]\nname = \"a\"\npath = \"relative\"", "not absolute"},
+		{"[[module]]\nname = \"a/b\"\npath = \"/a\"", "invalid module name"},
+		{"[[module]]\nname = \"a\"\npath = \"/a\"\n[[module]]\nname = \"a\"\npath = \"/b\"", "duplicate module a"},
+		{"[[module]]\nname = \"a\"\npath = \"/a\"\nread_only = true\nwrite_only = true", "mutually exclusive"},
+		{"[[module]]\nname = \"a\"\npath = \"/a\"\nmax_file_size = \"1X\"", "--max-size value is invalid"},
+		{"[[module]]\nname = \"a\"\npath = \"/a\"\nuid = -1", "invalid uid/gid"},
+	} {
+		_, err := rsyncd.ParseConfig(tt.config)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseConfig(%q) = %v, want error containing %q", tt.config, err, tt.want)
+		}
+	}
+}
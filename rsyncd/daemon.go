@@ -0,0 +1,216 @@
+package rsyncd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+)
+
+// Server serves Modules, either as an rsync daemon or as rsync --server.
+type Server struct {
+	Modules []Module
+	// Backends are the file systems of modules which set Backend.
+	Backends map[string]Backend
+	// UserRefuseOptions are refuse patterns (see Module.RefuseOptions) which
+	// apply to the transfers of an authenticated user, in addition to those
+	// of the module.
+	UserRefuseOptions map[string][]string
+	// HandshakeTimeout limits how long daemon clients may take to send their
+	// greeting, module name and arguments (see DefaultHandshakeTimeout).
+	HandshakeTimeout time.Duration
+	Logger           *slog.Logger
+}
+
+// DefaultHandshakeTimeout is the HandshakeTimeout of a zero Server.
+const DefaultHandshakeTimeout = time.Minute
+
+const (
+	// rsync/rsync.h:BIGPATHBUFLEN (MAXPATHLEN + 1024)
+	maxLineLen = 4096 + 1024
+	// maxArgs limits the number of arguments a daemon client may send.
+	maxArgs = 1000
+)
+
+func (s *Server) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
+}
+
+func (s *Server) module(name string) (*Module, bool) {
+	for i := range s.Modules {
+		if s.Modules[i].Name == name {
+			return &s.Modules[i], true
+		}
+	}
+	return nil, false
+}
+
+// Serve accepts connections on ln and handles each in a separate goroutine.
+func (s *Server) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := s.HandleDaemonConn(conn, conn.RemoteAddr()); err != nil {
+				s.logger().Error("rsync daemon connection", "remote", conn.RemoteAddr(), "err", err)
+			}
+		}()
+	}
+}
+
+// rsync/clientserver.c:start_daemon
+//
+// HandleDaemonConn speaks the rsync daemon protocol (rsync://) on conn: it
+// exchanges protocol versions, lists modules or selects the requested one,
+// reads the client’s arguments and runs the server side of the transfer.
+func (s *Server) HandleDaemonConn(conn io.ReadWriter, remoteAddr net.Addr) error {
+	logger := s.logger().With("remote", remoteAddr)
+
+	// The transfer enforces its own --timeout, but until then, clients must
+	// not be able to hold the connection open without sending anything.
+	deadliner, _ := conn.(interface{ SetReadDeadline(time.Time) error })
+	if deadliner != nil {
+		timeout := s.HandshakeTimeout
+		if timeout <= 0 {
+			timeout = DefaultHandshakeTimeout
+		}
+		if err := deadliner.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+	}
+
+	// rsync/clientserver.c:exchange_protocols
+	if _, err := fmt.Fprintf(conn, "@RSYNCD: %d.0\n", rsync.ProtocolVersion); err != nil {
+		return err
+	}
+	greeting, err := readLine(conn)
+	if err != nil {
+		return err
+	}
+	remoteVersion, ok := strings.CutPrefix(greeting, "@RSYNCD: ")
+	if !ok {
+		fmt.Fprintf(conn, "@ERROR: protocol startup error\n")
+		return rsync.Errorf(rsync.RERR_PROTOCOL, "invalid daemon greeting %q", greeting)
+	}
+	remoteVersion, _, _ = strings.Cut(remoteVersion, " ")
+	remoteVersion, _, _ = strings.Cut(remoteVersion, ".")
+	remoteProtocol, err := strconv.Atoi(remoteVersion)
+	if err != nil || remoteProtocol < rsync.ProtocolVersion {
+		fmt.Fprintf(conn, "@ERROR: protocol version mismatch\n")
+		return rsync.Errorf(rsync.RERR_PROTOCOL, "unsupported remote protocol %q", remoteVersion)
+	}
+
+	name, err := readLine(conn)
+	if err != nil {
+		return err
+	}
+	if name == "" || name == "#list" {
+		// rsync/clientserver.c:send_listing
+		for _, mod := range s.Modules {
+			if _, err := fmt.Fprintf(conn, "%-15s\t%s\n", mod.Name, mod.Comment); err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprintf(conn, "@RSYNCD: EXIT\n")
+		return err
+	}
+	mod, ok := s.module(name)
+	if !ok {
+		fmt.Fprintf(conn, "@ERROR: Unknown module '%s'\n", name)
+		return rsync.Errorf(rsync.RERR_SYNTAX, "unknown module %q", name)
+	}
+	logger = logger.With("module", mod.Name)
+	// We do not authenticate daemon clients.
+	if !mod.allows("") {
+		fmt.Fprintf(conn, "@ERROR: auth failed on module %s\n", mod.Name)
+		return rsync.Errorf(rsync.RERR_STARTCLIENT, "auth failed on module %s", mod.Name)
+	}
+
+	// rsync/clientserver.c:rsync_module
+	if _, err := fmt.Fprintf(conn, "@RSYNCD: OK\n"); err != nil {
+		return err
+	}
+	var args []string
+	for {
+		arg, err := readLine(conn)
+		if err != nil {
+			return err
+		}
+		if arg == "" {
+			break
+		}
+		if len(args) == maxArgs {
+			fmt.Fprintf(conn, "@ERROR: too many arguments\n")
+			return rsync.Errorf(rsync.RERR_PROTOCOL, "more than %d arguments", maxArgs)
+		}
+		args = append(args, arg)
+	}
+	logger.Debug("daemon arguments", "args", args)
+	if deadliner != nil {
+		if err := deadliner.SetReadDeadline(time.Time{}); err != nil {
+			return err
+		}
+	}
+
+	opts, paths, err := parseServerArgs(args, s.refuseOptions(mod, ""))
+	if err != nil {
+		return refuse(conn, false, err)
+	}
+	return s.runModule(logger, conn, mod, "", opts, stripModule(mod.Name, paths), false)
+}
+
+// rsync/io.c:read_line_old
+//
+// readLine reads a newline-terminated line of at most maxLineLen bytes from
+// r. It reads byte by byte to not consume any data of the transfer which
+// follows.
+func readLine(r io.Reader) (string, error) {
+	var (
+		line []byte
+		buf  [1]byte
+	)
+	for {
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			if errors.Is(err, io.EOF) && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		if buf[0] == '\n' {
+			return strings.TrimSuffix(string(line), "\r"), nil
+		}
+		if len(line) == maxLineLen {
+			return "", rsync.Errorf(rsync.RERR_PROTOCOL, "over-long line of more than %d bytes", maxLineLen)
+		}
+		line = append(line, buf[0])
+	}
+}
+
+// ParseModuleMap parses the --gokr.modulemap flag, a comma-separated list of
+// name=path pairs.
+func ParseModuleMap(moduleMap string) ([]Module, error) {
+	var modules []Module
+	for _, entry := range strings.Split(moduleMap, ",") {
+		if entry == "" {
+			continue
+		}
+		name, path, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || path == "" {
+			return nil, rsync.Errorf(rsync.RERR_SYNTAX, "invalid module map entry %q, expected name=path", entry)
+		}
+		modules = append(modules, Module{Name: name, Path: path})
+	}
+	return modules, nil
+}
@@ -0,0 +1,124 @@
+package rsyncd_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/rsyncd"
+)
+
+// daemonSession writes lines to a daemon connection and returns everything
+// the daemon replied and the error of HandleDaemonConn.
+func daemonSession(t *testing.T, srv *rsyncd.Server, lines ...string) (string, error) {
+	t.Helper()
+	client, server := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.HandleDaemonConn(server, server.RemoteAddr())
+		server.Close()
+	}()
+	rd := bufio.NewReader(client)
+	greeting, err := rd.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "@RSYNCD: 27.0\n"; greeting != want {
+		t.Fatalf("greeting = %q, want %q", greeting, want)
+	}
+	go func() {
+		for _, line := range lines {
+			fmt.Fprintf(client, "%s\n", line)
+		}
+	}()
+	reply, err := io.ReadAll(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(reply), <-done
+}
+
+func TestDaemonListModules(t *testing.T) {
+	srv := &rsyncd.Server{
+		Modules: []rsyncd.Module{
+			{Name: "photos", Path: t.TempDir(), Comment: "holiday pictures"},
+			{Name: "backup", Path: t.TempDir()},
+		},
+	}
+	got, err := daemonSession(t, srv, "@RSYNCD: 31.0 md5 md4", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "photos         \tholiday pictures\n" +
+		"backup         \t\n" +
+		"@RSYNCD: EXIT\n"
+	if got != want {
+		t.Errorf("module listing = %q, want %q", got, want)
+	}
+}
+
+func TestDaemonUnknownModule(t *testing.T) {
+	srv := &rsyncd.Server{}
+	got, _ := daemonSession(t, srv, "@RSYNCD: 31.0", "nope")
+	if want := "@ERROR: Unknown module 'nope'\n"; got != want {
+		t.Errorf("reply = %q, want %q", got, want)
+	}
+}
+
+func TestDaemonLimits(t *testing.T) {
+	srv := &rsyncd.Server{
+		Modules: []rsyncd.Module{{Name: "mod", Path: t.TempDir()}},
+	}
+
+	t.Run("LineLength", func(t *testing.T) {
+		_, err := daemonSession(t, srv, "@RSYNCD: 31.0", strings.Repeat("m", 5121))
+		if got, want := rsync.ExitCode(err), int(rsync.RERR_PROTOCOL); got != want {
+			t.Errorf("exit code = %d (%v), want %d", got, err, want)
+		}
+	})
+
+	t.Run("Arguments", func(t *testing.T) {
+		lines := []string{"@RSYNCD: 31.0", "mod"}
+		for range 1001 {
+			lines = append(lines, "-v")
+		}
+		got, err := daemonSession(t, srv, append(lines, "")...)
+		if want := "@RSYNCD: OK\n@ERROR: too many arguments\n"; got != want {
+			t.Errorf("reply = %q, want %q", got, want)
+		}
+		if got, want := rsync.ExitCode(err), int(rsync.RERR_PROTOCOL); got != want {
+			t.Errorf("exit code = %d (%v), want %d", got, err, want)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		srv := &rsyncd.Server{HandshakeTimeout: 10 * time.Millisecond}
+		_, err := daemonSession(t, srv, "@RSYNCD: 31.0")
+		if got, want := rsync.ExitCode(err), int(rsync.RERR_TIMEOUT); got != want {
+			t.Errorf("exit code = %d (%v), want %d", got, err, want)
+		}
+	})
+}
+
+func TestParseModuleMap(t *testing.T) {
+	modules, err := rsyncd.ParseModuleMap("interop=/tmp/interop,home=/home/michael")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, mod := range modules {
+		got = append(got, mod.Name+"="+mod.Path)
+	}
+	if want := []string{"interop=/tmp/interop", "home=/home/michael"}; !slices.Equal(got, want) {
+		t.Errorf("ParseModuleMap = %q, want %q", got, want)
+	}
+	if _, err := rsyncd.ParseModuleMap("broken"); err == nil || !strings.Contains(err.Error(), "name=path") {
+		t.Errorf("ParseModuleMap(broken) = %v, want error", err)
+	}
+}
@@ -0,0 +1,257 @@
+package rsyncd
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/picosh/go-rsync-receiver/localfs"
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/rsyncopts"
+	"github.com/picosh/go-rsync-receiver/rsyncreceiver"
+	"github.com/picosh/go-rsync-receiver/rsyncsender"
+	"github.com/picosh/go-rsync-receiver/rsyncwire"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+// Module is a tree of files which the server offers, like an rsyncd.conf
+// module. Clients address it by Name, e.g. rsync://host/Name/dir or, via
+// ssh, host:Name/dir.
+type Module struct {
+	Name string `toml:"name"`
+	// Path is the local directory of the module. Modules stored elsewhere
+	// (e.g. in object storage) name one of the Server’s Backends instead.
+	Path    string `toml:"path"`
+	Backend string `toml:"backend"`
+	Comment string `toml:"comment"`
+
+	// ReadOnly modules refuse uploads, WriteOnly modules refuse downloads.
+	ReadOnly  bool `toml:"read_only"`
+	WriteOnly bool `toml:"write_only"`
+
+	// Users are the authenticated users allowed to access the module. If
+	// empty, everyone (including anonymous daemon clients) is.
+	Users []string `toml:"users"`
+
+	// Filters are filter rules (like “- *.tmp”) whose excluded files are
+	// neither sent nor received, regardless of the client’s filter rules.
+	Filters []string `toml:"filters"`
+
+	// MaxFileSize is the size (like “100M”, see rsyncopts.ParseSizeArg) above
+	// which received files are skipped.
+	MaxFileSize string `toml:"max_file_size"`
+
+	// MaxFiles, MaxFileListSize and MaxTotalSize (sizes like MaxFileSize)
+	// limit uploads: transfers exceeding them are aborted (see
+	// rsyncreceiver.Limits).
+	MaxFiles        int    `toml:"max_files"`
+	MaxFileListSize string `toml:"max_file_list_size"`
+	MaxTotalSize    string `toml:"max_total_size"`
+
+	// RefuseOptions are options (with wildcards, e.g. “delete*”) which
+	// clients must not use, like the refuse options of rsyncd.conf. See
+	// rsyncopts.ParseArgumentsRefusing.
+	RefuseOptions []string `toml:"refuse_options"`
+
+	// UID and GID, if set, are the owner of all received files, regardless of
+	// the ids sent by the client. Changing the owner requires privileges and a
+	// file system implementing utils.Chowner (like localfs).
+	UID *int32 `toml:"uid"`
+	GID *int32 `toml:"gid"`
+}
+
+// Backend returns the file system of mod (whose Backend names it) for user,
+// rooted at dir within the module.
+type Backend func(mod *Module, user, dir string) (utils.FS, error)
+
+// validate checks the module’s settings, with backends being the names of
+// the available backends.
+func (m *Module) validate(backends map[string]Backend) error {
+	if m.Name == "" || strings.ContainsAny(m.Name, "/ \t\n") {
+		return fmt.Errorf("invalid module name %q", m.Name)
+	}
+	switch {
+	case m.Path == "" && m.Backend == "":
+		return fmt.Errorf("module %s: one of path or backend must be set", m.Name)
+	case m.Path != "" && m.Backend != "":
+		return fmt.Errorf("module %s: path and backend are mutually exclusive", m.Name)
+	case m.Path != "" && !filepath.IsAbs(m.Path):
+		return fmt.Errorf("module %s: path %q is not absolute", m.Name, m.Path)
+	}
+	if m.Backend != "" && backends != nil {
+		if _, ok := backends[m.Backend]; !ok {
+			return fmt.Errorf("module %s: unknown backend %q", m.Name, m.Backend)
+		}
+	}
+	if m.ReadOnly && m.WriteOnly {
+		return fmt.Errorf("module %s: read_only and write_only are mutually exclusive", m.Name)
+	}
+	if _, err := m.filterList(); err != nil {
+		return fmt.Errorf("module %s: %v", m.Name, err)
+	}
+	if _, err := m.maxFileSize(); err != nil {
+		return fmt.Errorf("module %s: %v", m.Name, err)
+	}
+	if _, err := m.limits(); err != nil {
+		return fmt.Errorf("module %s: %v", m.Name, err)
+	}
+	if err := rsyncopts.CheckRefuseOptions(m.RefuseOptions); err != nil {
+		return fmt.Errorf("module %s: %v", m.Name, err)
+	}
+	for _, id := range []*int32{m.UID, m.GID} {
+		if id != nil && *id < 0 {
+			return fmt.Errorf("module %s: invalid uid/gid %d", m.Name, *id)
+		}
+	}
+	return nil
+}
+
+func (m *Module) filterList() (*rsyncsender.FilterRuleList, error) {
+	if len(m.Filters) == 0 {
+		return nil, nil
+	}
+	var l rsyncsender.FilterRuleList
+	for _, rule := range m.Filters {
+		if err := l.AddRule(rule); err != nil {
+			return nil, err
+		}
+	}
+	return &l, nil
+}
+
+func (m *Module) maxFileSize() (int64, error) {
+	if m.MaxFileSize == "" {
+		return 0, nil
+	}
+	return rsyncopts.ParseSizeArg(m.MaxFileSize, 'b', "max-size", 1, -1, false)
+}
+
+func (m *Module) limits() (rsyncreceiver.Limits, error) {
+	l := rsyncreceiver.Limits{MaxFiles: m.MaxFiles}
+	if m.MaxFiles < 0 {
+		return l, fmt.Errorf("invalid max_files %d", m.MaxFiles)
+	}
+	for _, size := range []struct {
+		arg, name string
+		dst       *int64
+	}{
+		{m.MaxFileListSize, "max_file_list_size", &l.MaxFileListSize},
+		{m.MaxTotalSize, "max_total_size", &l.MaxTotalSize},
+	} {
+		if size.arg == "" {
+			continue
+		}
+		n, err := rsyncopts.ParseSizeArg(size.arg, 'b', size.name, 1, -1, false)
+		if err != nil {
+			return l, err
+		}
+		*size.dst = n
+	}
+	return l, nil
+}
+
+// allows reports whether user may access the module.
+func (m *Module) allows(user string) bool {
+	return len(m.Users) == 0 || slices.Contains(m.Users, user)
+}
+
+// rsync/clientserver.c:rsync_module
+//
+// authorize checks whether user may run the transfer described by opts.
+func (m *Module) authorize(opts *rsyncopts.Options, user string) error {
+	if !m.allows(user) {
+		return rsync.Errorf(rsync.RERR_STARTCLIENT, "auth failed on module %s", m.Name)
+	}
+	if !opts.Sender() && m.ReadOnly {
+		return rsync.Errorf(rsync.RERR_SYNTAX, "module is read only")
+	}
+	if opts.Sender() && m.WriteOnly {
+		return rsync.Errorf(rsync.RERR_SYNTAX, "module is write only")
+	}
+	return nil
+}
+
+// capabilities returns the operations which transfers may perform on the
+// module’s file system, which enforces the module’s settings even if a
+// transfer should get past authorize.
+func (m *Module) capabilities() *utils.Capabilities {
+	return &utils.Capabilities{
+		AllowRead:     !m.WriteOnly,
+		AllowWrite:    !m.ReadOnly,
+		AllowDelete:   !m.ReadOnly,
+		AllowMetadata: !m.ReadOnly,
+	}
+}
+
+// stripModule returns paths relative to the module name, which clients
+// prepend to paths (rsync://host/name/dir is sent as “name/dir”).
+func stripModule(name string, paths []string) []string {
+	stripped := make([]string, len(paths))
+	for i, path := range paths {
+		if path == name {
+			path = ""
+		} else if rest, ok := strings.CutPrefix(path, name+"/"); ok {
+			path = rest
+		}
+		stripped[i] = path
+	}
+	return stripped
+}
+
+// refuse reports err to a client which expects the server to start the
+// protocol, i.e. once it sent its arguments, and returns err. The caller must
+// close conn afterwards.
+func refuse(conn io.ReadWriter, negotiate bool, err error) error {
+	c := &rsyncwire.Conn{Reader: conn, Writer: conn}
+	if negotiate {
+		if _, err := c.ReadInt32(); err != nil {
+			return err
+		}
+	}
+	// The client might already be sending (e.g. its file list), which we
+	// discard so that it does not block receiving the error.
+	go io.Copy(io.Discard, conn)
+	if negotiate {
+		if err := c.WriteInt32(rsync.ProtocolVersion); err != nil {
+			return err
+		}
+	}
+	const sessionChecksumSeed = 666
+	if err := c.WriteInt32(sessionChecksumSeed); err != nil {
+		return err
+	}
+	rsyncwire.SendError(&rsyncwire.MultiplexWriter{Writer: conn}, fmt.Sprintf("ERROR: %v\n", err))
+	return err
+}
+
+// open returns the file system and the names within it of a transfer of
+// paths (relative to the module).
+func (s *Server) open(mod *Module, user string, opts *rsyncopts.Options, paths []string) (utils.FS, []string, error) {
+	if mod.Backend != "" {
+		backend, ok := s.Backends[mod.Backend]
+		if !ok {
+			return nil, nil, rsync.Errorf(rsync.RERR_FILESELECT, "module %s: unknown backend %q", mod.Name, mod.Backend)
+		}
+		if opts.Sender() {
+			fsys, err := backend(mod, user, "")
+			return fsys, paths, err
+		}
+		var dest string
+		if len(paths) > 0 {
+			dest = paths[0]
+		}
+		fsys, err := backend(mod, user, filepath.Clean("/"+dest))
+		return fsys, nil, err
+	}
+
+	if opts.Sender() {
+		return localfs.Sources(mod.Path, paths)
+	}
+	var dest string
+	if len(paths) > 0 {
+		dest = paths[0]
+	}
+	return localfs.New(filepath.Join(mod.Path, filepath.Clean("/"+dest))), nil, nil
+}
@@ -0,0 +1,142 @@
+// Package rsyncd serves rsync clients: either as rsync --server, which clients
+// start via a remote shell such as ssh, or as an rsync daemon, which clients
+// connect to via TCP (rsync://host/module).
+package rsyncd
+
+import (
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/rsyncopts"
+	"github.com/picosh/go-rsync-receiver/rsyncreceiver"
+	"github.com/picosh/go-rsync-receiver/rsyncsender"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+// ParseServerArgs parses the arguments of rsync --server (without the
+// program name), e.g. as received in an SSH exec request. It returns the
+// options and the paths of the transfer.
+func ParseServerArgs(args []string) (*rsyncopts.Options, []string, error) {
+	return parseServerArgs(args, nil)
+}
+
+// parseServerArgs is like ParseServerArgs, but refuses the options matching
+// the refuse patterns (see rsyncopts.ParseArgumentsRefusing).
+func parseServerArgs(args, refuse []string) (*rsyncopts.Options, []string, error) {
+	pc, err := rsyncopts.ParseArgumentsRefusing(args, refuse)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !pc.Options.Server() {
+		return nil, nil, rsync.Errorf(rsync.RERR_SYNTAX, "not an rsync --server command line")
+	}
+	paths := pc.RemainingArgs
+	// The first argument is the working directory, which is always ".".
+	if len(paths) > 0 && paths[0] == "." {
+		paths = paths[1:]
+	}
+	return pc.Options, paths, nil
+}
+
+// rsync/main.c:start_server
+//
+// RunServer runs the server side of a transfer over conn: if opts.Sender()
+// is true, it sends paths from filesystem, otherwise it stores the files it
+// receives in filesystem. negotiate is false for daemon connections, which
+// exchange protocol versions before the server starts.
+func RunServer(logger *slog.Logger, opts *rsyncopts.Options, paths []string, conn io.ReadWriter, filesystem utils.FS, negotiate bool) error {
+	if opts.Sender() {
+		return rsyncsender.ClientRun(logger, opts, conn, filesystem, paths, negotiate)
+	}
+	return rsyncreceiver.ClientRun(logger, opts, conn, filesystem, paths, negotiate)
+}
+
+// HandleServer runs rsync --server with args (as received e.g. in an SSH
+// exec request) for the authenticated user. Like with the daemon, the paths
+// start with the name of a module, e.g. “rsync -av src/ host:photos/2024/”.
+func (s *Server) HandleServer(conn io.ReadWriter, user string, args []string) error {
+	logger := s.logger().With("user", user)
+	opts, paths, err := ParseServerArgs(args)
+	if err != nil {
+		return refuse(conn, true, err)
+	}
+	if len(paths) == 0 {
+		return refuse(conn, true, rsync.Errorf(rsync.RERR_SYNTAX, "no module specified"))
+	}
+	name, _, _ := strings.Cut(strings.TrimPrefix(paths[0], "/"), "/")
+	mod, ok := s.module(name)
+	if !ok {
+		return refuse(conn, true, rsync.Errorf(rsync.RERR_FILESELECT, "Unknown module '%s'", name))
+	}
+	// The module (and thereby the options to refuse) is only known once the
+	// paths are parsed, so parse the arguments again.
+	if refused := s.refuseOptions(mod, user); len(refused) > 0 {
+		opts, paths, err = parseServerArgs(args, refused)
+		if err != nil {
+			return refuse(conn, true, err)
+		}
+	}
+	for i, path := range paths {
+		paths[i] = strings.TrimPrefix(path, "/")
+	}
+	return s.runModule(logger.With("module", mod.Name), conn, mod, user, opts, stripModule(mod.Name, paths), true)
+}
+
+// refuseOptions returns the refuse patterns which apply to user’s transfers
+// from or to mod.
+func (s *Server) refuseOptions(mod *Module, user string) []string {
+	return slices.Concat(mod.RefuseOptions, s.UserRefuseOptions[user])
+}
+
+// runModule runs the server side of a transfer of paths (relative to mod)
+// for user, enforcing the module’s settings.
+func (s *Server) runModule(logger *slog.Logger, conn io.ReadWriter, mod *Module, user string, opts *rsyncopts.Options, paths []string, negotiate bool) error {
+	if err := mod.authorize(opts, user); err != nil {
+		return refuse(conn, negotiate, err)
+	}
+	filesystem, names, err := s.open(mod, user, opts, paths)
+	if err != nil {
+		return refuse(conn, negotiate, err)
+	}
+	filters, err := mod.filterList()
+	if err != nil {
+		return refuse(conn, negotiate, err)
+	}
+
+	if opts.Sender() {
+		return rsyncsender.ClientRun(logger, opts, conn, filesystem, names, negotiate,
+			rsyncsender.WithFilters(filters),
+			rsyncsender.WithCapabilities(mod.capabilities()))
+	}
+
+	options := []rsyncreceiver.Option{
+		rsyncreceiver.WithFilters(filters),
+		rsyncreceiver.WithCapabilities(mod.capabilities()),
+	}
+	maxFileSize, err := mod.maxFileSize()
+	if err != nil {
+		return refuse(conn, negotiate, err)
+	}
+	if maxFileSize > 0 {
+		options = append(options, rsyncreceiver.WithMaxFileSize(maxFileSize))
+	}
+	limits, err := mod.limits()
+	if err != nil {
+		return refuse(conn, negotiate, err)
+	}
+	options = append(options, rsyncreceiver.WithLimits(limits))
+	if mod.UID != nil || mod.GID != nil {
+		uid, gid := int32(-1), int32(-1)
+		if mod.UID != nil {
+			uid = *mod.UID
+		}
+		if mod.GID != nil {
+			gid = *mod.GID
+		}
+		options = append(options, rsyncreceiver.WithOwner(uid, gid))
+	}
+	return rsyncreceiver.ClientRun(logger, opts, conn, filesystem, names, negotiate, options...)
+}
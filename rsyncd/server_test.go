@@ -0,0 +1,182 @@
+package rsyncd_test
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/picosh/go-rsync-receiver/localfs"
+	"github.com/picosh/go-rsync-receiver/rsyncclient"
+	"github.com/picosh/go-rsync-receiver/rsyncd"
+	"github.com/picosh/go-rsync-receiver/rsyncopts"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// session runs rsync args between the local directory and remote (a path
+// starting with a module name) on srv, as user. It returns the client’s
+// stderr and error.
+func session(t *testing.T, srv *rsyncd.Server, user, args, local, remote string, push bool) (string, error) {
+	t.Helper()
+	pc, err := rsyncopts.ParseArguments(strings.Fields(args), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := pc.Options
+	if push {
+		opts.SetSender()
+	}
+	serverArgs := rsyncclient.ServerArgs(opts, []string{remote})
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv.Logger = logger
+	clientConn, serverConn := net.Pipe()
+	go func() {
+		defer serverConn.Close()
+		srv.HandleServer(serverConn, user, serverArgs)
+	}()
+	var stderr bytes.Buffer
+	var names []string
+	if push {
+		names = []string{"."}
+	}
+	_, err = rsyncclient.Run(logger, opts, clientConn, localfs.New(local), names,
+		rsyncclient.WithOutput(io.Discard, &stderr))
+	clientConn.Close()
+	return stderr.String(), err
+}
+
+func TestServerModulePolicy(t *testing.T) {
+	local := t.TempDir()
+	writeFiles(t, local, map[string]string{
+		"photo.jpg":  "jpeg",
+		"upload.tmp": "partial",
+		"large.iso":  strings.Repeat("x", 2048),
+	})
+	readOnly := t.TempDir()
+	writeFiles(t, readOnly, map[string]string{
+		"public.txt": "public",
+		"cache.tmp":  "cache",
+	})
+	uploads := t.TempDir()
+
+	cfg, err := rsyncd.ParseConfig(`
+[[module]]
+name = "pub"
+path = "` + readOnly + `"
+read_only = true
+filters = ["- *.tmp"]
+
+[[module]]
+name = "uploads"
+path = "` + uploads + `"
+users = ["alice"]
+filters = ["- *.tmp"]
+max_file_size = "1K"
+
+[[module]]
+name = "mirror"
+path = "` + uploads + `"
+refuse_options = ["delete", "D"]
+
+[[module]]
+name = "quota"
+path = "` + t.TempDir() + `"
+max_total_size = "1K"
+max_files = 2
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &rsyncd.Server{Modules: cfg.Modules}
+
+	t.Run("ReadOnly", func(t *testing.T) {
+		stderr, err := session(t, srv, "alice", "-a", local, "pub/", true)
+		if err == nil {
+			t.Fatalf("push to read-only module unexpectedly succeeded")
+		}
+		if !strings.Contains(stderr, "module is read only") {
+			t.Errorf("stderr = %q, want read only error", stderr)
+		}
+	})
+
+	t.Run("PullFilters", func(t *testing.T) {
+		dst := t.TempDir()
+		if _, err := session(t, srv, "", "-a", dst, "pub/", false); err != nil {
+			t.Fatal(err)
+		}
+		if !exists(filepath.Join(dst, "public.txt")) {
+			t.Errorf("public.txt was not transferred")
+		}
+		if exists(filepath.Join(dst, "cache.tmp")) {
+			t.Errorf("daemon-excluded cache.tmp was transferred")
+		}
+	})
+
+	t.Run("Users", func(t *testing.T) {
+		stderr, err := session(t, srv, "mallory", "-a", local, "uploads/", true)
+		if err == nil || !strings.Contains(stderr, "auth failed on module uploads") {
+			t.Errorf("push as unlisted user: err = %v, stderr = %q", err, stderr)
+		}
+	})
+
+	t.Run("RefuseOptions", func(t *testing.T) {
+		stderr, err := session(t, srv, "", "-r --delete", local, "mirror/", true)
+		if err == nil || !strings.Contains(stderr, "The server is configured to refuse --delete") {
+			t.Errorf("push with --delete: err = %v, stderr = %q", err, stderr)
+		}
+		stderr, err = session(t, srv, "", "-a", local, "mirror/", true)
+		if err == nil || !strings.Contains(stderr, "The server is configured to refuse -D") {
+			t.Errorf("push with -a: err = %v, stderr = %q", err, stderr)
+		}
+	})
+
+	t.Run("Quota", func(t *testing.T) {
+		stderr, err := session(t, srv, "", "-r", local, "quota/", true)
+		if err == nil || !strings.Contains(stderr, "file list exceeds the server’s limit of 2 files") {
+			t.Errorf("push of too many files: err = %v, stderr = %q", err, stderr)
+		}
+		stderr, err = session(t, srv, "", "-r", filepath.Join(local, "large.iso"), "quota/", true)
+		if err == nil || !strings.Contains(stderr, "would exceed the server’s limit of 1024 bytes") {
+			t.Errorf("push over quota: err = %v, stderr = %q", err, stderr)
+		}
+	})
+
+	t.Run("Push", func(t *testing.T) {
+		stderr, err := session(t, srv, "alice", "-a", local, "uploads/", true)
+		// Daemon-excluded files are errors, like with rsync.
+		if err == nil || !strings.Contains(stderr, `skipping daemon-excluded file "upload.tmp"`) {
+			t.Errorf("err = %v, stderr = %q", err, stderr)
+		}
+		if !exists(filepath.Join(uploads, "photo.jpg")) {
+			t.Errorf("photo.jpg was not transferred")
+		}
+		if exists(filepath.Join(uploads, "upload.tmp")) {
+			t.Errorf("daemon-excluded upload.tmp was transferred")
+		}
+		if exists(filepath.Join(uploads, "large.iso")) {
+			t.Errorf("large.iso exceeds max_file_size, but was transferred")
+		}
+	})
+}
@@ -0,0 +1,374 @@
+// Package rsynclog renders rsync’s per-file log lines, i.e. the
+// --out-format (formerly --log-format) and --log-file-format escapes,
+// including the itemized change strings printed by rsync -i.
+package rsynclog
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
+)
+
+// DefaultFormat is the format which rsync uses for -i and for --log-file.
+const DefaultFormat = "%i %n%L"
+
+// Item describes a file which is logged.
+type Item struct {
+	Op         string // %o: "send", "recv" or "del."
+	Name       string // %n, %f
+	Mode       fs.FileMode
+	Size       int64 // %l
+	ModTime    time.Time
+	Uid        int32
+	Gid        int32
+	LinkTarget string // symlink target for %L
+	HardLink   string // name of the file which Name is hard-linked to, for %L
+
+	// Flags are the rsync.ITEM_* flags describing what changed.
+	Flags int32
+
+	Bytes         int64  // %b: bytes transferred
+	ChecksumBytes int64  // %c: bytes of block checksums
+	Checksum      []byte // %C: full-file checksum
+}
+
+// Formatter renders Items using an rsync log format.
+type Formatter struct {
+	Format string
+
+	// PreserveTimes selects between “t” and “T” in itemized output.
+	PreserveTimes bool
+	// LocalServer selects between “<” and “>” for sent files.
+	LocalServer bool
+
+	// Daemon context for %h, %a, %m, %P and %u.
+	Host       string
+	Addr       string
+	Module     string
+	ModulePath string
+	User       string
+
+	// Now returns the time for %t (time.Now if nil).
+	Now func() time.Time
+}
+
+// Has reports whether format contains the escape %c (with or without
+// modifiers).
+//
+// rsync/log.c:log_format_has
+func Has(format string, c byte) bool {
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			continue
+		}
+		for i < len(format) && (format[i] == '-' || format[i] == '\'' || (format[i] >= '0' && format[i] <= '9')) {
+			i++
+		}
+		if i < len(format) && format[i] == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Render returns the log line for it, without a trailing newline.
+//
+// rsync/log.c:log_formatted
+func (f *Formatter) Render(it *Item) string {
+	var b strings.Builder
+	format := f.Format
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		start := i
+		i++
+		leftJustify := false
+		humanize := 0
+		for i < len(format) && (format[i] == '-' || format[i] == '\'') {
+			if format[i] == '-' {
+				leftJustify = true
+			} else {
+				humanize++
+			}
+			i++
+		}
+		width := 0
+		for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+			width = width*10 + int(format[i]-'0')
+			i++
+		}
+		if i >= len(format) {
+			b.WriteString(format[start:])
+			break
+		}
+		val, ok := f.escape(format[i], humanize, it)
+		if !ok {
+			// Unknown escapes are output verbatim.
+			b.WriteString(format[start : i+1])
+			continue
+		}
+		if pad := width - len(val); pad > 0 {
+			if leftJustify {
+				val += strings.Repeat(" ", pad)
+			} else {
+				val = strings.Repeat(" ", pad) + val
+			}
+		}
+		b.WriteString(val)
+	}
+	return b.String()
+}
+
+func (f *Formatter) escape(c byte, humanize int, it *Item) (string, bool) {
+	switch c {
+	case '%':
+		return "%", true
+	case 'h':
+		return f.Host, true
+	case 'a':
+		return f.Addr, true
+	case 'm':
+		return f.Module, true
+	case 'P':
+		return f.ModulePath, true
+	case 'u':
+		return f.User, true
+	case 'p':
+		return strconv.Itoa(os.Getpid()), true
+	case 't':
+		now := time.Now
+		if f.Now != nil {
+			now = f.Now
+		}
+		return now().Format("2006/01/02 15:04:05"), true
+	case 'o':
+		return it.Op, true
+	case 'n':
+		if it.Mode.IsDir() && it.Flags&rsync.ITEM_DELETED == 0 {
+			return it.Name + "/", true
+		}
+		return it.Name, true
+	case 'f':
+		return it.Name, true
+	case 'L':
+		switch {
+		case it.HardLink != "":
+			return " => " + it.HardLink, true
+		case it.Mode&fs.ModeSymlink != 0 && it.LinkTarget != "":
+			return " -> " + it.LinkTarget, true
+		}
+		return "", true
+	case 'l':
+		return HumanNum(it.Size, humanize), true
+	case 'b':
+		return HumanNum(it.Bytes, humanize), true
+	case 'c':
+		return HumanNum(it.ChecksumBytes, humanize), true
+	case 'C':
+		if len(it.Checksum) == 0 || !it.Mode.IsRegular() {
+			return "", true
+		}
+		return hex.EncodeToString(it.Checksum), true
+	case 'M':
+		return it.ModTime.Format("2006/01/02-15:04:05"), true
+	case 'B':
+		return Permstring(it.Mode)[1:], true
+	case 'U':
+		return strconv.Itoa(int(it.Uid)), true
+	case 'G':
+		return strconv.Itoa(int(it.Gid)), true
+	case 'i':
+		return f.itemize(it), true
+	}
+	return "", false
+}
+
+// itemize returns the 11 character itemized change string for it, e.g.
+// “>f.st......”.
+func (f *Formatter) itemize(it *Item) string {
+	iflags := it.Flags
+	if iflags&rsync.ITEM_DELETED != 0 {
+		return "*deleting  "
+	}
+	flag := func(mask int32, set byte) byte {
+		if iflags&mask == 0 {
+			return '.'
+		}
+		return set
+	}
+	var c [11]byte
+	switch {
+	case iflags&rsync.ITEM_LOCAL_CHANGE != 0:
+		c[0] = 'c'
+		if iflags&rsync.ITEM_XNAME_FOLLOWS != 0 {
+			c[0] = 'h'
+		}
+	case iflags&rsync.ITEM_TRANSFER == 0:
+		c[0] = '.'
+	case !f.LocalServer && it.Op == "send":
+		c[0] = '<'
+	default:
+		c[0] = '>'
+	}
+	mode := it.Mode
+	if mode&fs.ModeSymlink != 0 {
+		c[1] = 'L'
+		c[3] = '.'
+		c[4] = '.'
+		if iflags&rsync.ITEM_REPORT_TIME != 0 {
+			c[4] = 't'
+			if !f.PreserveTimes || iflags&rsync.ITEM_REPORT_TIMEFAIL != 0 {
+				c[4] = 'T'
+			}
+		}
+	} else {
+		switch {
+		case mode.IsDir():
+			c[1] = 'd'
+		case mode&(fs.ModeNamedPipe|fs.ModeSocket) != 0:
+			c[1] = 'S'
+		case mode&fs.ModeDevice != 0:
+			c[1] = 'D'
+		default:
+			c[1] = 'f'
+		}
+		c[3] = flag(rsync.ITEM_REPORT_SIZE, 's')
+		c[4] = '.'
+		if iflags&rsync.ITEM_REPORT_TIME != 0 {
+			c[4] = 't'
+			if !f.PreserveTimes {
+				c[4] = 'T'
+			}
+		}
+	}
+	c[2] = flag(rsync.ITEM_REPORT_CHANGE, 'c')
+	c[5] = flag(rsync.ITEM_REPORT_PERMS, 'p')
+	c[6] = flag(rsync.ITEM_REPORT_OWNER, 'o')
+	c[7] = flag(rsync.ITEM_REPORT_GROUP, 'g')
+	switch {
+	case iflags&rsync.ITEM_REPORT_ATIME != 0 && iflags&rsync.ITEM_REPORT_CRTIME != 0:
+		c[8] = 'b'
+	case iflags&rsync.ITEM_REPORT_ATIME != 0:
+		c[8] = 'u'
+	case iflags&rsync.ITEM_REPORT_CRTIME != 0:
+		c[8] = 'n'
+	default:
+		c[8] = '.'
+	}
+	c[9] = flag(rsync.ITEM_REPORT_ACL, 'a')
+	c[10] = flag(rsync.ITEM_REPORT_XATTR, 'x')
+
+	if iflags&(rsync.ITEM_IS_NEW|rsync.ITEM_MISSING_DATA) != 0 {
+		ch := byte('?')
+		if iflags&rsync.ITEM_IS_NEW != 0 {
+			ch = '+'
+		}
+		for i := 2; i < len(c); i++ {
+			c[i] = ch
+		}
+	} else if c[0] == '.' || c[0] == 'h' || c[0] == 'c' {
+		// Hide the attribute columns if nothing changed.
+		unchanged := true
+		for _, ch := range c[2:] {
+			if ch != '.' {
+				unchanged = false
+				break
+			}
+		}
+		if unchanged {
+			for i := 2; i < len(c); i++ {
+				c[i] = ' '
+			}
+		}
+	}
+	return string(c[:])
+}
+
+// Permstring returns the ls-style permission string for mode, e.g.
+// “drwxr-xr-x”.
+//
+// rsync/lib/permstring.c:permstring
+func Permstring(mode fs.FileMode) string {
+	const permMap = "rwxrwxrwx"
+	perms := []byte("----------")
+	for i := 0; i < 9; i++ {
+		if mode&(1<<i) != 0 {
+			perms[9-i] = permMap[8-i]
+		}
+	}
+	if mode&fs.ModeSetuid != 0 {
+		perms[3] = 'S'
+		if mode&0o100 != 0 {
+			perms[3] = 's'
+		}
+	}
+	if mode&fs.ModeSetgid != 0 {
+		perms[6] = 'S'
+		if mode&0o010 != 0 {
+			perms[6] = 's'
+		}
+	}
+	if mode&fs.ModeSticky != 0 {
+		perms[9] = 'T'
+		if mode&0o001 != 0 {
+			perms[9] = 't'
+		}
+	}
+	switch {
+	case mode.IsDir():
+		perms[0] = 'd'
+	case mode&fs.ModeSymlink != 0:
+		perms[0] = 'l'
+	case mode&fs.ModeCharDevice != 0:
+		perms[0] = 'c'
+	case mode&fs.ModeDevice != 0:
+		perms[0] = 'b'
+	case mode&fs.ModeSocket != 0:
+		perms[0] = 's'
+	case mode&fs.ModeNamedPipe != 0:
+		perms[0] = 'p'
+	}
+	return string(perms)
+}
+
+// rsync/util1.c:do_big_num
+//
+// HumanNum formats num with thousands separators (humanize == 1) or as a
+// number of kilo-, mega-, … bytes in units of 1000 (humanize == 2) or 1024
+// (humanize > 2). humanize corresponds to rsync’s human_readable, i.e. the
+// number of -h options plus one.
+func HumanNum(num int64, humanize int) string {
+	if humanize > 1 {
+		mult := int64(1000)
+		if humanize > 2 {
+			mult = 1024
+		}
+		if num >= mult || num <= -mult {
+			const units = "KMGTPE"
+			dnum := float64(num) / float64(mult)
+			unit := 0
+			for (dnum >= float64(mult) || dnum <= -float64(mult)) && unit < len(units)-1 {
+				dnum /= float64(mult)
+				unit++
+			}
+			return fmt.Sprintf("%.2f%c", dnum, units[unit])
+		}
+	}
+	if humanize > 0 {
+		return rsyncstats.CommaNum(num)
+	}
+	return strconv.FormatInt(num, 10)
+}
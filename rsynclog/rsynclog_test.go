@@ -0,0 +1,94 @@
+package rsynclog_test
+
+import (
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/rsynclog"
+)
+
+func TestItemize(t *testing.T) {
+	f := &rsynclog.Formatter{Format: "%i %n%L", PreserveTimes: true}
+	for _, tt := range []struct {
+		it   rsynclog.Item
+		want string
+	}{
+		{
+			it:   rsynclog.Item{Op: "recv", Name: "new.txt", Flags: rsync.ITEM_TRANSFER | rsync.ITEM_IS_NEW},
+			want: ">f+++++++++ new.txt",
+		},
+		{
+			it:   rsynclog.Item{Op: "recv", Name: "changed.txt", Flags: rsync.ITEM_TRANSFER | rsync.ITEM_REPORT_SIZE | rsync.ITEM_REPORT_TIME},
+			want: ">f.st...... changed.txt",
+		},
+		{
+			it:   rsynclog.Item{Op: "send", Name: "sent.txt", Flags: rsync.ITEM_TRANSFER},
+			want: "<f......... sent.txt",
+		},
+		{
+			it:   rsynclog.Item{Op: "recv", Name: "dir", Mode: fs.ModeDir | 0o755, Flags: rsync.ITEM_LOCAL_CHANGE | rsync.ITEM_IS_NEW},
+			want: "cd+++++++++ dir/",
+		},
+		{
+			it:   rsynclog.Item{Op: "recv", Name: "same.txt"},
+			want: ".f          same.txt",
+		},
+		{
+			it:   rsynclog.Item{Op: "recv", Name: "link", HardLink: "orig", Flags: rsync.ITEM_LOCAL_CHANGE | rsync.ITEM_XNAME_FOLLOWS},
+			want: "hf          link => orig",
+		},
+		{
+			it:   rsynclog.Item{Op: "recv", Name: "sym", Mode: fs.ModeSymlink | 0o777, LinkTarget: "target", Flags: rsync.ITEM_IS_NEW},
+			want: ".L+++++++++ sym -> target",
+		},
+		{
+			it:   rsynclog.Item{Op: "del.", Name: "gone", Mode: fs.ModeDir, Flags: rsync.ITEM_DELETED},
+			want: "*deleting   gone",
+		},
+	} {
+		if got := f.Render(&tt.it); got != tt.want {
+			t.Errorf("Render(%+v) = %q, want %q", tt.it, got, tt.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	f := &rsynclog.Formatter{
+		Format: "%t %o %-6n|%8l|%'l|%''l|%B|%M|%q|100%%",
+		Now:    func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) },
+	}
+	it := &rsynclog.Item{
+		Op:      "recv",
+		Name:    "a.txt",
+		Mode:    0o644,
+		Size:    1234567,
+		ModTime: time.Date(2023, 12, 31, 23, 59, 58, 0, time.UTC),
+	}
+	want := "2024/01/02 03:04:05 recv a.txt | 1234567|1,234,567|1.23M|rw-r--r--|2023/12/31-23:59:58|%q|100%"
+	if got := f.Render(it); got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+	if !rsynclog.Has(f.Format, 'l') || rsynclog.Has(f.Format, 'i') {
+		t.Errorf("Has() reports wrong escapes for %q", f.Format)
+	}
+}
+
+func TestPermstring(t *testing.T) {
+	for _, tt := range []struct {
+		mode fs.FileMode
+		want string
+	}{
+		{0o644, "-rw-r--r--"},
+		{fs.ModeDir | 0o755, "drwxr-xr-x"},
+		{fs.ModeSymlink | 0o777, "lrwxrwxrwx"},
+		{fs.ModeSetuid | 0o755, "-rwsr-xr-x"},
+		{fs.ModeDir | fs.ModeSticky | 0o777, "drwxrwxrwt"},
+		{fs.ModeDevice | fs.ModeCharDevice | 0o600, "crw-------"},
+	} {
+		if got := rsynclog.Permstring(tt.mode); got != tt.want {
+			t.Errorf("Permstring(%v) = %q, want %q", tt.mode, got, tt.want)
+		}
+	}
+}
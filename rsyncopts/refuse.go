@@ -0,0 +1,144 @@
+package rsyncopts
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// RefusedError is returned (wrapped in an *rsync.Error) by
+// ParseArgumentsRefusing for an option which the server refuses.
+type RefusedError struct {
+	// Option names the refused option, e.g. “--delete” or “--compress (-z)”.
+	Option string
+}
+
+// rsync/options.c:create_refuse_error
+func (e *RefusedError) Error() string {
+	return "The server is configured to refuse " + e.Option
+}
+
+func refusedError(opt *poptOption) error {
+	name := opt.name()
+	if opt.longName != "" && opt.shortName != "" {
+		name += " (-" + opt.shortName + ")"
+	}
+	return &RefusedError{Option: name}
+}
+
+// refusal holds the refused options which are also implied by other
+// options, like rsync’s refused_* variables.
+type refusal struct {
+	archivePart *poptOption // one of the options implied by -a
+	compress    *poptOption
+	delete      *poptOption
+	partial     *poptOption
+}
+
+// unrefusable options are never matched by refuse patterns, as the server
+// could not function without them.
+var unrefusable = map[string]bool{
+	"server": true,
+	"sender": true,
+}
+
+// wildcardExempt reports whether opt is only refused when named explicitly,
+// not by wildcard patterns, so that e.g. “* !a !v” still accepts the options
+// which clients send regardless of the user’s command line.
+func wildcardExempt(opt *poptOption) bool {
+	switch opt.shortName {
+	case "e", // carries the protocol compatibility flags
+		"0", // only modifies --files-from, which can be refused instead
+		"s", // --secluded-args is always OK
+		"n": // --dry-run is always OK
+		return true
+	}
+	switch opt.longName {
+	case "iconv", "no-iconv", "checksum-seed",
+		"copy-devices", "write-devices",
+		"log-format": // aka --out-format, lets the client choose a format
+		return true
+	}
+	return false
+}
+
+// rsync/options.c:set_refuse_options
+//
+// refuseOptions marks the options of table which match patterns as refused:
+// poptGetNextOpt returns OPT_REFUSED_BASE plus their index instead of
+// storing their value. It returns the patterns which did not match any
+// option.
+func refuseOptions(table []poptOption, patterns []string) (*refusal, []string) {
+	refused := make([]bool, len(table))
+	var unmatched []string
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		wild := strings.ContainsAny(pattern, "*?[")
+		found := false
+		for idx, opt := range table {
+			if unrefusable[opt.longName] || (wild && wildcardExempt(&opt)) {
+				continue
+			}
+			if match(pattern, opt.longName) || match(pattern, opt.shortName) {
+				refused[idx] = !negated
+				found = true
+			}
+		}
+		if !found {
+			unmatched = append(unmatched, pattern)
+		}
+	}
+
+	var ref refusal
+	for idx := range table {
+		if !refused[idx] {
+			continue
+		}
+		opt := &table[idx]
+		if opt.argInfo&POPT_ARG_MASK == POPT_ARG_VAL {
+			opt.argInfo = POPT_ARG_NONE
+		}
+		opt.arg = nil
+		opt.val = OPT_REFUSED_BASE + idx
+		switch opt.shortName {
+		case "r", "d", "l", "p", "t", "g", "o", "D":
+			ref.archivePart = opt
+		case "z":
+			ref.compress = opt
+		case "":
+			switch opt.longName {
+			case "delete":
+				ref.delete = opt
+			case "partial":
+				ref.partial = opt
+			}
+		}
+	}
+	return &ref, unmatched
+}
+
+// match reports whether name matches the wildcard pattern (like rsync’s
+// wildmatch, which for option names is equivalent to path.Match).
+func match(pattern, name string) bool {
+	if name == "" {
+		return false
+	}
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
+
+// CheckRefuseOptions returns an error if one of the refuse patterns (see
+// ParseArgumentsRefusing) is malformed or does not match any option.
+func CheckRefuseOptions(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(strings.TrimPrefix(pattern, "!"), ""); err != nil {
+			return fmt.Errorf("invalid refuse option pattern %q: %v", pattern, err)
+		}
+	}
+	opts := NewOptions()
+	if _, unmatched := refuseOptions(opts.table(), patterns); len(unmatched) > 0 {
+		return fmt.Errorf("no match for refuse option %q", unmatched[0])
+	}
+	return nil
+}
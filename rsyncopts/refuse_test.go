@@ -0,0 +1,66 @@
+package rsyncopts_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/rsyncopts"
+)
+
+func TestParseArgumentsRefusing(t *testing.T) {
+	for _, tt := range []struct {
+		args   string
+		refuse []string
+		want   string // refused option, or empty if args are accepted
+	}{
+		{"--server -r --delete .", []string{"delete"}, "--delete"},
+		{"--server -r --delete-after .", []string{"delete"}, "--delete"},
+		{"--server -r --delete-after .", []string{"delete*"}, "--delete-after"},
+		{"--server -a .", []string{"D"}, "-D"},
+		{"--server -D .", []string{"D"}, "-D"},
+		{"--server -z .", []string{"compress"}, "--compress (-z)"},
+		{"--server --remove-source-files .", []string{"remove-*"}, "--remove-source-files"},
+		{"--server -r --delete-after .", []string{"delete-*", "!delete-after"}, ""},
+		{"--server -a .", []string{"delete"}, ""},
+		// The options a stock rsync client sends for -av (rlptgoD instead of
+		// -a and -e with its compatibility flags), with everything else refused.
+		{"--server -vlogDtpre.iLsfxC . .", []string{"*", "!a", "!v", "![rlptgoD]"}, ""},
+		{"--server -n -e.iLsfxC --log-format=%i .", []string{"*"}, ""},
+		{"--server -r .", []string{"*"}, "--recursive (-r)"},
+		{"--server -n .", []string{"dry-run"}, "--dry-run (-n)"},
+	} {
+		_, err := rsyncopts.ParseArgumentsRefusing(strings.Fields(tt.args), tt.refuse)
+		var refused *rsyncopts.RefusedError
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%q refusing %q: %v", tt.args, tt.refuse, err)
+		case tt.want == "":
+		case !errors.As(err, &refused):
+			t.Errorf("%q refusing %q: err = %v, want RefusedError", tt.args, tt.refuse, err)
+		case refused.Option != tt.want:
+			t.Errorf("%q refusing %q: refused %q, want %q", tt.args, tt.refuse, refused.Option, tt.want)
+		}
+		if err != nil && rsync.ExitCode(err) != int(rsync.RERR_SYNTAX) {
+			t.Errorf("%q: exit code %d, want RERR_SYNTAX", tt.args, rsync.ExitCode(err))
+		}
+	}
+
+	_, err := rsyncopts.ParseArgumentsRefusing([]string{"--server", "--delete", "."}, []string{"delete"})
+	if want := "The server is configured to refuse --delete"; err == nil || err.Error() != want {
+		t.Errorf("error = %v, want %q", err, want)
+	}
+}
+
+func TestCheckRefuseOptions(t *testing.T) {
+	if err := rsyncopts.CheckRefuseOptions([]string{"delete*", "D", "!delete-excluded"}); err != nil {
+		t.Errorf("CheckRefuseOptions: %v", err)
+	}
+	if err := rsyncopts.CheckRefuseOptions([]string{"no-such-option"}); err == nil {
+		t.Errorf("CheckRefuseOptions(no-such-option) unexpectedly succeeded")
+	}
+	if err := rsyncopts.CheckRefuseOptions([]string{"[delete"}); err == nil {
+		t.Errorf("CheckRefuseOptions([delete) unexpectedly succeeded")
+	}
+}
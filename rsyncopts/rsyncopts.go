@@ -17,6 +17,8 @@ import (
 	"strings"
 	"syscall"
 	"unicode"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
 )
 
 const (
@@ -59,6 +61,16 @@ const (
 	OPT_REFUSED_BASE = 9000
 )
 
+// rsync.h
+const (
+	COMPARE_DEST = 1 + iota
+	COPY_DEST
+	LINK_DEST
+)
+
+// rsync.h
+const MAX_BASIS_DIRS = 20
+
 type infoLevel int
 
 const (
@@ -91,6 +103,8 @@ func NewOptions() *Options {
 		relative_paths:       -1,
 		implied_dirs:         1,
 		max_delete:           math.MinInt32,
+		max_size:             -1,
+		min_size:             -1,
 		whole_file:           -1,
 		do_compression_level: math.MinInt32,
 		rsync_path:           "rsync",
@@ -128,6 +142,8 @@ type Options struct {
 	do_compression int
 	info           [COUNT_INFO]uint16
 	local_server   int
+	basis_dir      []string
+	alt_dest_type  int
 
 	// order matches long_options order
 	verbose                int
@@ -176,6 +192,8 @@ type Options struct {
 	ignore_existing        int
 	max_size_arg           string
 	min_size_arg           string
+	max_size               int64
+	min_size               int64
 	max_alloc_arg          string
 	sparse_files           int
 	preallocate_files      int
@@ -371,6 +389,11 @@ func (o *Options) PreserveHardLinks() bool    { return o.preserve_hard_links !=
 func (o *Options) Recurse() bool              { return o.recurse != 0 }
 func (o *Options) Verbose() bool              { return o.verbose != 0 }
 func (o *Options) DeleteMode() bool           { return o.delete_mode != 0 }
+func (o *Options) DeleteBefore() bool         { return o.delete_before != 0 }
+func (o *Options) DeleteDuring() bool         { return o.delete_during == 1 }
+func (o *Options) DeleteDelay() bool          { return o.delete_during == 2 }
+func (o *Options) DeleteAfter() bool          { return o.delete_after != 0 }
+func (o *Options) DeleteExcluded() bool       { return o.delete_excluded != 0 }
 func (o *Options) Sender() bool               { return o.am_sender != 0 }
 func (o *Options) SetSender()                 { o.am_sender = 1 }
 func (o *Options) LocalServer() bool          { return o.local_server != 0 }
@@ -378,12 +401,80 @@ func (o *Options) SetLocalServer()            { o.local_server = 1 }
 func (o *Options) Server() bool               { return o.am_server != 0 }
 func (o *Options) Daemon() bool               { return o.am_daemon != 0 }
 func (o *Options) ConnectTimeoutSeconds() int { return o.connect_timeout }
+func (o *Options) IOTimeoutSeconds() int      { return o.io_timeout }
 func (o *Options) AlwaysChecksum() bool       { return o.always_checksum != 0 }
 func (o *Options) Compress() bool             { return o.do_compression != 0 }
 func (o *Options) CompressChoice() string     { return o.compress_choice }
 func (o *Options) CompressLevel() int         { return o.do_compression_level }
 func (o *Options) IgnoreTimes() bool          { return o.ignore_times == 1 }
 func (o *Options) SizeOnly() bool             { return o.size_only == 1 }
+func (o *Options) MakeBackups() bool          { return o.make_backups != 0 }
+func (o *Options) BackupDir() string          { return o.backup_dir }
+func (o *Options) BackupSuffix() string       { return o.backup_suffix }
+func (o *Options) BasisDirs() []string        { return o.basis_dir }
+func (o *Options) AltDestType() int           { return o.alt_dest_type }
+func (o *Options) FuzzyBasis() int            { return o.fuzzy_basis }
+
+// HumanReadable returns rsync’s human_readable level: 0 with
+// --no-human-readable, 1 by default (thousands separators), 2 or more with -h
+// (units of 1000 or 1024).
+func (o *Options) HumanReadable() int { return o.human_readable }
+
+// ListOnly reports whether --list-only was specified.
+func (o *Options) ListOnly() bool { return o.list_only != 0 }
+
+// ItemizeChanges returns how often -i was specified (-ii also itemizes
+// unchanged files).
+func (o *Options) ItemizeChanges() int { return o.itemize_changes }
+
+// StdoutFormat returns the --out-format, which defaults to "%i %n%L" with -i
+// and to "%n%L" with -v.
+func (o *Options) StdoutFormat() string { return o.stdout_format }
+
+// LogFileName returns the --log-file name.
+func (o *Options) LogFileName() string { return o.logfile_name }
+
+// LogFileFormat returns the --log-file-format.
+func (o *Options) LogFileFormat() string { return o.logfile_format }
+
+// RsyncPath returns the --rsync-path, i.e. the program which is started on
+// the remote host ("rsync" by default).
+func (o *Options) RsyncPath() string { return o.rsync_path }
+
+// StatsLevel returns the level of the stats output: 1 for the summary printed
+// with -v, 2 for the detailed statistics printed with --stats.
+func (o *Options) StatsLevel() int {
+	if o.do_stats != 0 {
+		return max(int(o.info[INFO_STATS]), 2)
+	}
+	return int(o.info[INFO_STATS])
+}
+
+// Port returns the --port of the daemon, or 0 if unset.
+func (o *Options) Port() int { return o.rsync_port }
+
+// BindAddress returns the --address the daemon binds to.
+func (o *Options) BindAddress() string { return o.bind_address }
+
+// ConfigFile returns the --config file of the daemon.
+func (o *Options) ConfigFile() string { return o.config_file }
+
+// BwLimit returns the --bwlimit in KiB per second, or 0 if unlimited.
+func (o *Options) BwLimit() int { return o.bwlimit }
+
+// MaxSize returns the --max-size in bytes, or -1 if unset.
+func (o *Options) MaxSize() int64 { return o.max_size }
+
+// MinSize returns the --min-size in bytes, or -1 if unset.
+func (o *Options) MinSize() int64 { return o.min_size }
+
+// MaxDelete returns the --max-delete limit, or -1 if deletions are unlimited.
+func (o *Options) MaxDelete() int {
+	if o.max_delete == math.MinInt32 {
+		return -1
+	}
+	return o.max_delete
+}
 
 func (o *Options) daemonTable() []poptOption {
 	return []poptOption{
@@ -670,13 +761,43 @@ func (o *Options) table() []poptOption {
 	}
 }
 
-var errNotYetImplemented = errors.New("option not yet implemented in gokrazy/rsync")
+var errNotYetImplemented = rsync.NewError(rsync.RERR_UNSUPPORTED, errors.New("option not yet implemented in gokrazy/rsync"))
 
 // rsync/options.c:parse_arguments
+//
+// Errors are returned as *rsync.Error with exit code RERR_SYNTAX (or
+// RERR_UNSUPPORTED for options which are not implemented).
 func ParseArguments(args []string, gokrazyTable bool) (*Context, error) {
-	// NOTE: We do not implement support for refusing options per rsyncd.conf
-	// here, as we have our own configuration file.
+	return parseArgumentsRefusing(args, gokrazyTable, nil)
+}
+
+// ParseArgumentsRefusing is like ParseArguments for a server, but fails with
+// a *RefusedError if args use an option matching one of the refuse patterns,
+// like the refuse options of rsyncd.conf. Patterns match long or short
+// option names and may contain wildcards (e.g. “delete*”); a pattern starting
+// with “!” exempts the options it matches from earlier patterns. Options
+// implied by others are refused, too: refusing “delete” refuses all
+// --delete-WHEN options, and refusing one of -rlptgoD refuses -a.
+// Like in rsync, wildcards never match a few options which clients send
+// regardless of the user’s command line (e.g. -e and --log-format), and
+// --server and --sender cannot be refused at all.
+func ParseArgumentsRefusing(args []string, refuse []string) (*Context, error) {
+	return parseArgumentsRefusing(args, false, refuse)
+}
+
+func parseArgumentsRefusing(args []string, gokrazyTable bool, refuse []string) (*Context, error) {
+	pc, err := parseArguments(args, gokrazyTable, refuse)
+	if err != nil {
+		var rerr *rsync.Error
+		if !errors.As(err, &rerr) {
+			err = rsync.NewError(rsync.RERR_SYNTAX, err)
+		}
+		return nil, err
+	}
+	return pc, nil
+}
 
+func parseArguments(args []string, gokrazyTable bool, refuse []string) (*Context, error) {
 	version_opt_cnt := 0
 
 	opts := NewOptions()
@@ -686,6 +807,7 @@ func ParseArguments(args []string, gokrazyTable bool) (*Context, error) {
 		// attempt fails and the daemon mode parsing is never run.
 		table = slices.Concat(opts.Gokrazy.table(), table)
 	}
+	refused, _ := refuseOptions(table, refuse)
 	pc := Context{
 		Options: opts,
 		table:   table,
@@ -700,6 +822,9 @@ func ParseArguments(args []string, gokrazyTable bool) (*Context, error) {
 		if opt == -1 {
 			break // done
 		}
+		if opt >= OPT_REFUSED_BASE {
+			return nil, refusedError(&table[opt-OPT_REFUSED_BASE])
+		}
 		// Most options are handled by poptGetNextOpt, only special cases
 		// are returned and handled here.
 		switch opt {
@@ -759,6 +884,9 @@ func ParseArguments(args []string, gokrazyTable bool) (*Context, error) {
 			return nil, errNotYetImplemented
 
 		case 'a':
+			if refused.archivePart != nil {
+				return nil, refusedError(refused.archivePart)
+			}
 			if opts.recurse == 0 {
 				opts.recurse = 1
 			}
@@ -797,7 +925,7 @@ func ParseArguments(args []string, gokrazyTable bool) (*Context, error) {
 			opts.verbose++
 
 		case 'y':
-			return nil, errNotYetImplemented
+			opts.fuzzy_basis++
 
 		case 'q':
 			opts.quiet++
@@ -839,10 +967,28 @@ func ParseArguments(args []string, gokrazyTable bool) (*Context, error) {
 		case OPT_BLOCK_SIZE:
 			return nil, errNotYetImplemented
 
-		case OPT_MAX_SIZE, // (needs parse_size_arg)
-			OPT_MIN_SIZE,
-			OPT_BWLIMIT:
-			return nil, errNotYetImplemented
+		case OPT_MAX_SIZE:
+			size, err := ParseSizeArg(opts.max_size_arg, 'b', "max-size", 0, -1, false)
+			if err != nil {
+				return nil, err
+			}
+			opts.max_size = size
+			opts.max_size_arg = strconv.FormatInt(size, 10)
+
+		case OPT_MIN_SIZE:
+			size, err := ParseSizeArg(opts.min_size_arg, 'b', "min-size", 0, -1, false)
+			if err != nil {
+				return nil, err
+			}
+			opts.min_size = size
+			opts.min_size_arg = strconv.FormatInt(size, 10)
+
+		case OPT_BWLIMIT:
+			size, err := ParseSizeArg(opts.bwlimit_arg, 'K', "bwlimit", 512, -1, true)
+			if err != nil {
+				return nil, err
+			}
+			opts.bwlimit = int((size + 512) / 1024)
 
 		case OPT_APPEND:
 			return nil, errNotYetImplemented
@@ -850,7 +996,24 @@ func ParseArguments(args []string, gokrazyTable bool) (*Context, error) {
 		case OPT_LINK_DEST,
 			OPT_COPY_DEST,
 			OPT_COMPARE_DEST:
-			return nil, errNotYetImplemented
+			destOption := "--compare-dest"
+			altDestType := COMPARE_DEST
+			switch opt {
+			case OPT_LINK_DEST:
+				destOption = "--link-dest"
+				altDestType = LINK_DEST
+			case OPT_COPY_DEST:
+				destOption = "--copy-dest"
+				altDestType = COPY_DEST
+			}
+			if opts.alt_dest_type != 0 && opts.alt_dest_type != altDestType {
+				return nil, fmt.Errorf("you may not mix --compare-dest, --copy-dest, and --link-dest")
+			}
+			opts.alt_dest_type = altDestType
+			if len(opts.basis_dir) >= MAX_BASIS_DIRS {
+				return nil, fmt.Errorf("at most %d %s args may be specified", MAX_BASIS_DIRS, destOption)
+			}
+			opts.basis_dir = append(opts.basis_dir, pc.poptGetOptArg())
 
 		case OPT_CHMOD: // (needs parse_chmod):
 			return nil, errNotYetImplemented
@@ -919,6 +1082,38 @@ func ParseArguments(args []string, gokrazyTable bool) (*Context, error) {
 		opts.missing_args = 2
 	}
 
+	if opts.delete_before+min(opts.delete_during, 1)+opts.delete_after > 1 {
+		return nil, fmt.Errorf("you may not combine multiple --delete-WHEN options")
+	}
+	if opts.delete_before != 0 || opts.delete_during != 0 || opts.delete_after != 0 {
+		opts.delete_mode = 1
+	} else if opts.delete_mode != 0 || opts.delete_excluded != 0 {
+		// Only choose now between before & during if one is not already set.
+		if opts.protocol_version >= 30 {
+			opts.delete_during = 1
+		} else {
+			opts.delete_before = 1
+		}
+		opts.delete_mode = 1
+	}
+	if opts.delete_mode != 0 && refused.delete != nil {
+		return nil, refusedError(refused.delete)
+	}
+	if opts.do_compression != 0 && refused.compress != nil {
+		return nil, refusedError(refused.compress)
+	}
+	if opts.keep_partial != 0 && refused.partial != nil {
+		return nil, refusedError(refused.partial)
+	}
+	if opts.xfer_dirs == 0 && opts.delete_mode != 0 {
+		return nil, fmt.Errorf("--delete does not work without --recursive (-r) or --dirs (-d)")
+	}
+
+	if opts.max_delete < 0 && opts.max_delete != math.MinInt32 {
+		// Negative numbers are treated as "no deletions".
+		opts.max_delete = 0
+	}
+
 	if opts.backup_suffix == "" && opts.backup_dir == "" {
 		opts.backup_suffix = "~"
 	}
@@ -933,9 +1128,17 @@ func ParseArguments(args []string, gokrazyTable bool) (*Context, error) {
 		}
 	}
 
+	if opts.stdout_format == "" && opts.itemize_changes != 0 {
+		opts.stdout_format = "%i %n%L"
+	}
+
 	if opts.info[INFO_NAME] >= 1 && opts.stdout_format == "" {
 		opts.stdout_format = "%n%L"
 	}
 
+	if opts.logfile_name != "" && opts.logfile_format == "" {
+		opts.logfile_format = "%i %n%L"
+	}
+
 	return &pc, nil
 }
@@ -0,0 +1,278 @@
+package rsyncopts
+
+import (
+	"math"
+	"strconv"
+)
+
+// rsync/options.c:server_options
+//
+// BuildServerArgs returns the arguments which a client using o passes to the
+// remote rsync, starting with --server: the single-letter options are
+// combined into one argument (e.g. “-vlogDtpr”), followed by long options.
+// The caller appends “.” and the paths of the remote side. ParseArguments
+// turns the result into the server’s Options.
+//
+// As we only speak protocol 27, the -e capability string which rsync sends
+// to protocol 30 servers is omitted.
+func BuildServerArgs(o *Options) []string {
+	args := []string{"--server"}
+	if o.am_sender == 0 {
+		args = append(args, "--sender")
+	}
+
+	argstr := []byte{'-'}
+	repeat := func(n int, c byte) {
+		for i := 0; i < n; i++ {
+			argstr = append(argstr, c)
+		}
+	}
+	flag := func(set int, c byte) {
+		if set != 0 {
+			argstr = append(argstr, c)
+		}
+	}
+	repeat(o.verbose, 'v')
+	flag(o.quiet, 'q')
+	flag(o.make_backups, 'b')
+	flag(o.update_only, 'u')
+	flag(o.dry_run, 'n')
+	flag(o.preserve_links, 'l')
+	if (o.xfer_dirs >= 2 && o.xfer_dirs < 4) ||
+		(o.xfer_dirs != 0 && o.recurse == 0 && (o.list_only != 0 || (o.delete_mode != 0 && o.am_sender != 0))) {
+		argstr = append(argstr, 'd')
+	}
+	if o.am_sender != 0 {
+		flag(o.keep_dirlinks, 'K')
+		flag(o.prune_empty_dirs, 'm')
+		flag(o.omit_dir_times, 'O')
+		flag(o.omit_link_times, 'J')
+		repeat(min(o.fuzzy_basis, 2), 'y')
+	} else {
+		flag(o.copy_links, 'L')
+		flag(o.copy_dirlinks, 'k')
+	}
+	if o.whole_file > 0 {
+		argstr = append(argstr, 'W')
+	}
+	flag(o.preserve_hard_links, 'H')
+	flag(o.preserve_uid, 'o')
+	flag(o.preserve_gid, 'g')
+	flag(o.preserve_devices, 'D')
+	flag(o.preserve_mtimes, 't')
+	repeat(min(o.preserve_atimes, 2), 'U')
+	flag(o.preserve_crtimes, 'N')
+	if o.preserve_perms != 0 {
+		argstr = append(argstr, 'p')
+	} else if o.preserve_executability != 0 && o.am_sender != 0 {
+		argstr = append(argstr, 'E')
+	}
+	flag(o.preserve_acls, 'A')
+	repeat(o.preserve_xattrs, 'X')
+	flag(o.recurse, 'r')
+	flag(o.always_checksum, 'c')
+	flag(o.cvs_exclude, 'C')
+	flag(o.ignore_times, 'I')
+	flag(o.relative_paths, 'R')
+	repeat(o.one_file_system, 'x')
+	flag(o.sparse_files, 'S')
+	flag(o.do_compression, 'z')
+	repeat(o.itemize_changes, 'i')
+	if len(argstr) > 1 {
+		args = append(args, string(argstr))
+	}
+
+	if o.preserve_devices != o.preserve_specials {
+		if o.preserve_specials != 0 {
+			args = append(args, "--specials")
+		} else {
+			args = append(args, "--no-specials")
+		}
+	}
+	if o.list_only > 1 {
+		args = append(args, "--list-only")
+	}
+	if o.stdout_format != "" && o.stdout_format != impliedStdoutFormat(o) {
+		args = append(args, "--out-format="+o.stdout_format)
+	}
+	if o.io_timeout != 0 {
+		args = append(args, "--timeout="+strconv.Itoa(o.io_timeout))
+	}
+	if o.bwlimit != 0 {
+		args = append(args, "--bwlimit="+strconv.Itoa(o.bwlimit))
+	}
+	if o.backup_dir != "" {
+		args = append(args, "--backup-dir", o.backup_dir)
+	}
+	// Only send --suffix if it specifies a non-default value.
+	defaultSuffix := "~"
+	if o.backup_dir != "" {
+		defaultSuffix = ""
+	}
+	if o.backup_suffix != defaultSuffix {
+		args = append(args, "--suffix="+o.backup_suffix)
+	}
+
+	if o.delete_mode != 0 {
+		switch {
+		case o.delete_before != 0:
+			args = append(args, "--delete-before")
+		case o.delete_during == 2:
+			args = append(args, "--delete-delay")
+		case o.delete_during != 0:
+			args = append(args, "--delete-during")
+		case o.delete_after != 0:
+			args = append(args, "--delete-after")
+		case o.delete_excluded == 0:
+			args = append(args, "--delete")
+		}
+	}
+	if o.delete_excluded != 0 {
+		args = append(args, "--delete-excluded")
+	}
+	if o.force_delete != 0 {
+		args = append(args, "--force")
+	}
+	if o.ignore_errors != 0 {
+		args = append(args, "--ignore-errors")
+	}
+	switch {
+	case o.max_delete == 0:
+		args = append(args, "--max-delete=-1")
+	case o.max_delete > 0:
+		args = append(args, "--max-delete="+strconv.Itoa(o.max_delete))
+	}
+	if o.size_only != 0 {
+		args = append(args, "--size-only")
+	}
+	if o.max_alloc_arg != "" {
+		args = append(args, "--max-alloc="+o.max_alloc_arg)
+	}
+	if o.checksum_seed != 0 {
+		args = append(args, "--checksum-seed="+strconv.Itoa(o.checksum_seed))
+	}
+	if o.tmpdir != "" {
+		args = append(args, "--temp-dir", o.tmpdir)
+	}
+	if o.checksum_choice != "" {
+		args = append(args, "--checksum-choice="+o.checksum_choice)
+	}
+	if o.compress_choice != "" {
+		args = append(args, "--compress-choice="+o.compress_choice)
+	}
+	if o.do_compression_level != math.MinInt32 {
+		args = append(args, "--compress-level="+strconv.Itoa(o.do_compression_level))
+	}
+	if o.skip_compress != "" {
+		args = append(args, "--skip-compress="+o.skip_compress)
+	}
+
+	// Options which only the receiving side uses.
+	if o.am_sender != 0 {
+		if o.max_size_arg != "" {
+			args = append(args, "--max-size="+o.max_size_arg)
+		}
+		if o.min_size_arg != "" {
+			args = append(args, "--min-size="+o.min_size_arg)
+		}
+		if o.modify_window != 0 {
+			args = append(args, "--modify-window="+strconv.Itoa(o.modify_window))
+		}
+		if o.partial_dir != "" {
+			args = append(args, "--partial-dir", o.partial_dir)
+			if o.delay_updates != 0 {
+				args = append(args, "--delay-updates")
+			}
+		} else if o.keep_partial != 0 {
+			args = append(args, "--partial")
+		}
+		if o.ignore_existing != 0 {
+			args = append(args, "--ignore-existing")
+		}
+		if o.ignore_non_existing != 0 {
+			args = append(args, "--existing")
+		}
+		if o.mkpath_dest_arg != 0 {
+			args = append(args, "--mkpath")
+		}
+		if o.write_devices != 0 {
+			args = append(args, "--write-devices")
+		}
+		for _, dir := range o.basis_dir {
+			args = append(args, altDestOption(o.alt_dest_type), dir)
+		}
+	} else {
+		if o.copy_devices != 0 {
+			args = append(args, "--copy-devices")
+		}
+		switch o.remove_source_files {
+		case 1:
+			args = append(args, "--remove-source-files")
+		case 2:
+			args = append(args, "--remove-sent-files")
+		}
+	}
+
+	switch o.append_mode {
+	case 1:
+		args = append(args, "--append")
+	case 2:
+		args = append(args, "--append-verify")
+	}
+	if o.inplace != 0 {
+		args = append(args, "--inplace")
+	}
+	if o.numeric_ids != 0 {
+		args = append(args, "--numeric-ids")
+	}
+	if o.copy_unsafe_links != 0 {
+		args = append(args, "--copy-unsafe-links")
+	}
+	if o.safe_symlinks != 0 {
+		args = append(args, "--safe-links")
+	}
+	if o.munge_symlinks != 0 {
+		args = append(args, "--munge-links")
+	}
+	switch o.missing_args {
+	case 2:
+		args = append(args, "--delete-missing-args")
+	case 1:
+		args = append(args, "--ignore-missing-args")
+	}
+	if o.preallocate_files != 0 {
+		args = append(args, "--preallocate")
+	}
+	if o.do_fsync != 0 {
+		args = append(args, "--fsync")
+	}
+	switch {
+	case o.am_root > 1:
+		args = append(args, "--super")
+	case o.am_root < 0:
+		args = append(args, "--fake-super")
+	}
+	return args
+}
+
+// impliedStdoutFormat returns the --out-format which ParseArguments derives
+// from -i or -v, which the server derives on its own.
+func impliedStdoutFormat(o *Options) string {
+	switch {
+	case o.itemize_changes != 0:
+		return "%i %n%L"
+	case o.info[INFO_NAME] >= 1:
+		return "%n%L"
+	}
+	return ""
+}
+
+func altDestOption(altDestType int) string {
+	switch altDestType {
+	case COPY_DEST:
+		return "--copy-dest"
+	case LINK_DEST:
+		return "--link-dest"
+	}
+	return "--compare-dest"
+}
@@ -0,0 +1,116 @@
+package rsyncopts_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/picosh/go-rsync-receiver/rsyncopts"
+)
+
+// serverFields returns the options which the server needs to know about.
+func serverFields(o *rsyncopts.Options) map[string]any {
+	return map[string]any{
+		"UpdateOnly":        o.UpdateOnly(),
+		"DryRun":            o.DryRun(),
+		"PreserveLinks":     o.PreserveLinks(),
+		"PreserveUid":       o.PreserveUid(),
+		"PreserveGid":       o.PreserveGid(),
+		"PreserveDevices":   o.PreserveDevices(),
+		"PreserveSpecials":  o.PreserveSpecials(),
+		"PreserveMTimes":    o.PreserveMTimes(),
+		"PreservePerms":     o.PreservePerms(),
+		"PreserveHardLinks": o.PreserveHardLinks(),
+		"Recurse":           o.Recurse(),
+		"Verbose":           o.Verbose(),
+		"DeleteMode":        o.DeleteMode(),
+		"DeleteBefore":      o.DeleteBefore(),
+		"DeleteDuring":      o.DeleteDuring(),
+		"DeleteDelay":       o.DeleteDelay(),
+		"DeleteAfter":       o.DeleteAfter(),
+		"DeleteExcluded":    o.DeleteExcluded(),
+		"IOTimeoutSeconds":  o.IOTimeoutSeconds(),
+		"AlwaysChecksum":    o.AlwaysChecksum(),
+		"IgnoreTimes":       o.IgnoreTimes(),
+		"SizeOnly":          o.SizeOnly(),
+		"MakeBackups":       o.MakeBackups(),
+		"BackupDir":         o.BackupDir(),
+		"BackupSuffix":      o.BackupSuffix(),
+		"BasisDirs":         o.BasisDirs(),
+		"AltDestType":       o.AltDestType(),
+		"FuzzyBasis":        o.FuzzyBasis(),
+		"ItemizeChanges":    o.ItemizeChanges(),
+		"StdoutFormat":      o.StdoutFormat(),
+		"BwLimit":           o.BwLimit(),
+		"MaxDelete":         o.MaxDelete(),
+		"ListOnly":          o.ListOnly(),
+	}
+}
+
+func TestBuildServerArgsRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		args   string
+		sender bool // whether the client sends (push)
+	}{
+		{"-av --delete", true},
+		{"-av", false},
+		{"-rlt --delete-after --max-delete=5 --backup-dir=old --suffix=.bak", true},
+		{"-rn -ii --size-only --timeout=30 --bwlimit=100", true},
+		{"-r --link-dest=/a --link-dest=/b -yy -c -I", true},
+		{"-ruD --no-specials", true},
+		{"-vvr --delete-delay --delete-excluded", true},
+		{"-rH --max-delete=0 --out-format=%n %l", true},
+		{"-r --list-only", false},
+		{"-rt --compare-dest=/c", false},
+	} {
+		t.Run(tt.args, func(t *testing.T) {
+			pc, err := rsyncopts.ParseArguments(strings.Fields(tt.args), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := pc.Options
+			if tt.sender {
+				client.SetSender()
+			}
+			serverArgs := rsyncopts.BuildServerArgs(client)
+			spc, err := rsyncopts.ParseArguments(append(serverArgs, ".", "path"), false)
+			if err != nil {
+				t.Fatalf("ParseArguments(%q): %v", serverArgs, err)
+			}
+			server := spc.Options
+			if !server.Server() {
+				t.Errorf("server options: Server() = false")
+			}
+			if got, want := server.Sender(), !tt.sender; got != want {
+				t.Errorf("server options: Sender() = %v, want %v", got, want)
+			}
+
+			want := serverFields(client)
+			if !tt.sender {
+				// Receiver-side options are not sent to a sending server.
+				want["BasisDirs"] = []string(nil)
+				want["AltDestType"] = 0
+				want["FuzzyBasis"] = 0
+			}
+			if got := serverFields(server); !reflect.DeepEqual(got, want) {
+				for key := range want {
+					if !reflect.DeepEqual(got[key], want[key]) {
+						t.Errorf("%s: got %#v, want %#v (server args %q)", key, got[key], want[key], serverArgs)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBuildServerArgs(t *testing.T) {
+	pc, err := rsyncopts.ParseArguments([]string{"-av", "--delete-after"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := rsyncopts.BuildServerArgs(pc.Options)
+	want := []string{"--server", "--sender", "-vlogDtpr", "--delete-after"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildServerArgs(-av --delete-after) = %q, want %q", got, want)
+	}
+}
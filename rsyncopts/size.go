@@ -0,0 +1,93 @@
+package rsyncopts
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// rsync/options.c:parse_size_arg
+//
+// ParseSizeArg parses a size like “1.5M”, “100KB” or “1G-1”. A suffix of K,
+// M, G, T or P (optionally followed by “iB”) multiplies by powers of 1024,
+// whereas a suffix followed by “B” multiplies by powers of 1000. Without a
+// suffix, defSuffix is used. The result must be within minValue and maxValue
+// (if non-negative); zero is allowed regardless of minValue if unlimited0 is
+// set. optType names the option in error messages.
+func ParseSizeArg(sizeArg string, defSuffix byte, optType string, minValue, maxValue int64, unlimited0 bool) (int64, error) {
+	invalid := fmt.Errorf("--%s value is invalid: %s", optType, sizeArg)
+
+	arg := sizeArg
+	num := strings.TrimLeft(arg, "0123456789")
+	if strings.HasPrefix(num, ".") {
+		num = strings.TrimLeft(num[1:], "0123456789")
+	}
+	digits := arg[:len(arg)-len(num)]
+	arg = num
+
+	suffix := defSuffix
+	if arg != "" && arg[0] != '+' && arg[0] != '-' {
+		suffix = arg[0]
+		arg = arg[1:]
+	}
+	var reps int
+	switch suffix {
+	case 'b', 'B':
+		reps = 0
+	case 'k', 'K':
+		reps = 1
+	case 'm', 'M':
+		reps = 2
+	case 'g', 'G':
+		reps = 3
+	case 't', 'T':
+		reps = 4
+	case 'p', 'P':
+		reps = 5
+	default:
+		return -1, invalid
+	}
+
+	var mult float64
+	switch {
+	case strings.HasPrefix(arg, "b") || strings.HasPrefix(arg, "B"):
+		mult = 1000
+		arg = arg[1:]
+	case arg == "" || arg[0] == '+' || arg[0] == '-':
+		mult = 1024
+	case strings.EqualFold(arg[:min(2, len(arg))], "ib"):
+		mult = 1024
+		arg = arg[2:]
+	default:
+		return -1, invalid
+	}
+
+	f, err := strconv.ParseFloat(digits, 64)
+	if err != nil {
+		return -1, invalid
+	}
+	size := 1.0
+	for range reps {
+		size *= mult
+	}
+	result := int64(size * f)
+	if (strings.HasPrefix(arg, "+1") || strings.HasPrefix(arg, "-1")) && digits != "" {
+		if arg[0] == '+' {
+			result++
+		} else {
+			result--
+		}
+		arg = arg[2:]
+	}
+	if arg != "" {
+		return -1, invalid
+	}
+
+	if result < 0 || (maxValue >= 0 && result > maxValue) {
+		return -1, fmt.Errorf("--%s value is too large: %s (max: %d)", optType, sizeArg, maxValue)
+	}
+	if result < minValue && (!unlimited0 || result != 0) {
+		return -1, fmt.Errorf("--%s value is too small: %s (min: %d)", optType, sizeArg, minValue)
+	}
+	return result, nil
+}
@@ -0,0 +1,70 @@
+package rsyncopts_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/picosh/go-rsync-receiver/rsyncopts"
+)
+
+func TestParseSizeArg(t *testing.T) {
+	for _, tt := range []struct {
+		arg  string
+		want int64
+	}{
+		{"100", 100 * 1024},
+		{"0", 0},
+		{"1.5M", 1536 * 1024},
+		{"10KB", 10000},
+		{"1MiB", 1024 * 1024},
+		{"1G-1", 1<<30 - 1},
+		{"4b", 4},
+	} {
+		got, err := rsyncopts.ParseSizeArg(tt.arg, 'K', "bwlimit", 0, -1, true)
+		if err != nil {
+			t.Errorf("ParseSizeArg(%q): %v", tt.arg, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSizeArg(%q) = %d, want %d", tt.arg, got, tt.want)
+		}
+	}
+
+	for _, arg := range []string{"", "x", "1X", "1Kx", "1+2"} {
+		if _, err := rsyncopts.ParseSizeArg(arg, 'K', "bwlimit", 0, -1, true); err == nil {
+			t.Errorf("ParseSizeArg(%q) unexpectedly succeeded", arg)
+		}
+	}
+	if _, err := rsyncopts.ParseSizeArg("100b", 'K', "bwlimit", 512, -1, true); err == nil {
+		t.Errorf("ParseSizeArg(100b) with min 512 unexpectedly succeeded")
+	}
+}
+
+func TestMaxMinSize(t *testing.T) {
+	pc, err := rsyncopts.ParseArguments([]string{"--max-size=1.5K", "--min-size=10"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pc.Options.MaxSize(), int64(1536); got != want {
+		t.Errorf("MaxSize = %d, want %d", got, want)
+	}
+	if got, want := pc.Options.MinSize(), int64(10); got != want {
+		t.Errorf("MinSize = %d, want %d", got, want)
+	}
+	pc.Options.SetSender()
+	args := strings.Join(rsyncopts.BuildServerArgs(pc.Options), " ")
+	if !strings.Contains(args, "--max-size=1536") || !strings.Contains(args, "--min-size=10") {
+		t.Errorf("BuildServerArgs = %q, want normalized --max-size and --min-size", args)
+	}
+
+	pc, err = rsyncopts.ParseArguments(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pc.Options.MaxSize() != -1 || pc.Options.MinSize() != -1 {
+		t.Errorf("MaxSize, MinSize = %d, %d without options, want -1, -1", pc.Options.MaxSize(), pc.Options.MinSize())
+	}
+	if _, err := rsyncopts.ParseArguments([]string{"--max-size=big"}, false); err == nil {
+		t.Errorf("--max-size=big unexpectedly succeeded")
+	}
+}
@@ -0,0 +1,164 @@
+package rsyncreceiver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/rsyncopts"
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+// setBasisFile records that the generator sent checksums of basis instead of
+// the destination file, so that the receiver applies the delta to the same
+// file.
+func (rt *Transfer) setBasisFile(f *utils.ReceiverFile, basis string) {
+	rt.basisMu.Lock()
+	defer rt.basisMu.Unlock()
+	if rt.basisFiles == nil {
+		rt.basisFiles = make(map[string]string)
+	}
+	rt.basisFiles[f.Name] = basis
+}
+
+// basisFile returns the name of the file which the delta for f applies to.
+func (rt *Transfer) basisFile(f *utils.ReceiverFile) string {
+	rt.basisMu.Lock()
+	defer rt.basisMu.Unlock()
+	if basis, ok := rt.basisFiles[f.Name]; ok {
+		return basis
+	}
+	return f.Name
+}
+
+// checkBasisDirs refuses basis directories outside of the destination. rsync
+// resolves relative basis directories against the destination, so
+// “--link-dest=../prev” names a sibling of it, but Files cannot reach
+// outside of the destination. Rather than silently using a different
+// directory, such transfers fail.
+func (rt *Transfer) checkBasisDirs() error {
+	for _, dir := range rt.Opts.BasisDirs {
+		if clean := filepath.Clean(dir); clean == ".." || strings.HasPrefix(clean, "../") {
+			return rsync.Errorf(rsync.RERR_SYNTAX, "basis directory %s is outside of the destination", dir)
+		}
+	}
+	return nil
+}
+
+// basisName returns the name of name in the alternate basis directory dir.
+// Like all other names, basis directories are relative to the destination,
+// even when specified as an absolute path (see checkBasisDirs).
+func basisName(dir, name string) string {
+	return strings.TrimPrefix(filepath.Clean("/"+filepath.Join(dir, name)), "/")
+}
+
+// rsync/generator.c:unchanged_attrs
+func (rt *Transfer) unchangedAttrs(f *utils.ReceiverFile, st os.FileInfo) bool {
+	if rt.Opts.PreservePerms && st.Mode().Perm() != f.FileMode().Perm() {
+		return false
+	}
+	return true
+}
+
+// rsync/generator.c:try_dests_reg
+//
+// tryDestsReg looks for f in the alternate basis directories (--compare-dest,
+// --copy-dest or --link-dest). It returns done == true when there is nothing
+// left to transfer for f, or otherwise the name of the best basis file found
+// (empty if there is none). Files are linked or copied from a basis directory
+// only if the Policy and Limits permit receiving them.
+func (rt *Transfer) tryDestsReg(f *utils.ReceiverFile) (basis string, done bool, _ error) {
+	var bestMatch string
+	matchLevel := 0
+	for _, dir := range rt.Opts.BasisDirs {
+		name := basisName(dir, f.Name)
+		st, in, err := rt.readFile(&utils.SenderFile{
+			WPath:   name,
+			Regular: true,
+		})
+		if err != nil {
+			continue
+		}
+		in.Close()
+		if !st.Mode().IsRegular() {
+			continue
+		}
+
+		level := 1
+		if unchanged, err := rt.skipFile(f, st); err != nil {
+			return "", false, err
+		} else if unchanged {
+			level = 2
+			if rt.unchangedAttrs(f, st) {
+				level = 3
+			}
+		}
+		if level > matchLevel {
+			bestMatch = name
+			matchLevel = level
+		}
+		if matchLevel == 3 {
+			break
+		}
+	}
+
+	if matchLevel == 3 && rt.Opts.AltDestType == rsyncopts.COMPARE_DEST {
+		// Unchanged files in a --compare-dest are not created.
+		rt.Logger.Debug("unchanged in compare-dest, skipping", "file", f, "basis", bestMatch)
+		rt.obs().OnSkip(observed(f), rsyncstats.SkipBasisDir)
+		return "", true, rt.reportItem(f, 0, "")
+	}
+	if matchLevel >= 2 {
+		if ok, err := rt.consult(OpReceive, f); !ok || err != nil {
+			return "", true, err
+		}
+		if err := rt.reserve(f); err != nil {
+			return "", true, err
+		}
+		rt.stats.CreatedFiles.Add(f.FileMode())
+		rt.obs().OnSkip(observed(f), rsyncstats.SkipBasisDir)
+	}
+
+	if matchLevel == 3 && rt.Opts.AltDestType == rsyncopts.LINK_DEST {
+		if !rt.Opts.DryRun {
+			if err := rt.linkFile(bestMatch, f); err != nil {
+				return "", false, err
+			}
+		}
+		return "", true, rt.reportItem(f, rsync.ITEM_LOCAL_CHANGE|rsync.ITEM_XNAME_FOLLOWS|rsync.ITEM_IS_NEW, "")
+	}
+
+	if matchLevel >= 2 {
+		// Copy the file locally instead of transferring it.
+		if !rt.Opts.DryRun {
+			if err := rt.copyFile(bestMatch, f); err != nil {
+				return "", false, err
+			}
+		}
+		rt.Logger.Debug("copied from alternate basis", "file", f, "basis", bestMatch)
+		return "", true, rt.reportItem(f, rsync.ITEM_LOCAL_CHANGE|rsync.ITEM_IS_NEW, "")
+	}
+
+	return bestMatch, false, nil
+}
+
+// rsync/generator.c:copy_altdest_file
+func (rt *Transfer) copyFile(src string, f *utils.ReceiverFile) error {
+	_, in, err := rt.readFile(&utils.SenderFile{
+		WPath:   src,
+		Regular: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	cp := *f
+	cp.Reader = in
+	if _, err := rt.putFile(&cp); err != nil {
+		return err
+	}
+	return rt.setOwner(f)
+}
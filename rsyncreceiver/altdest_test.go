@@ -0,0 +1,115 @@
+package rsyncreceiver_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+)
+
+func TestAltDest(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{"sub/a.txt": "content"})
+
+	// Match levels of the basis file, like rsync/generator.c:try_dests_reg.
+	const (
+		differs   = 1 // a basis for the delta transfer
+		unchanged = 2 // same size and mtime, but different permissions
+		identical = 3 // same attributes
+	)
+	for _, tt := range []struct {
+		dest   string
+		level  int
+		exists bool // whether sub/a.txt is created
+		linked bool // whether sub/a.txt is a hard link to the basis file
+	}{
+		{"--compare-dest", identical, false, false},
+		{"--compare-dest", unchanged, true, false},
+		{"--copy-dest", identical, true, false},
+		{"--copy-dest", unchanged, true, false},
+		{"--link-dest", identical, true, true},
+		{"--link-dest", unchanged, true, false},
+	} {
+		t.Run(fmt.Sprintf("%s/level%d", tt.dest, tt.level), func(t *testing.T) {
+			dst := t.TempDir()
+			writeFiles(t, dst, map[string]string{"base/sub/a.txt": "content"})
+			basis := filepath.Join(dst, "base", "sub", "a.txt")
+			if tt.level == unchanged {
+				if err := os.Chmod(basis, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := receive(t, "-rp "+tt.dest+"=base", src, dst); err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]string{"base/sub/a.txt": "content"}
+			if tt.exists {
+				want["sub/a.txt"] = "content"
+			}
+			checkFiles(t, dst, want)
+			if !tt.exists {
+				return
+			}
+			fi, err := os.Stat(filepath.Join(dst, "sub", "a.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0o644 {
+				t.Errorf("sub/a.txt: mode %v, want 0644", fi.Mode().Perm())
+			}
+			bfi, err := os.Stat(basis)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := os.SameFile(fi, bfi); got != tt.linked {
+				t.Errorf("sub/a.txt linked to the basis file: %v, want %v", got, tt.linked)
+			}
+		})
+	}
+
+	// A basis file which differs serves as the basis for a delta transfer.
+	var b strings.Builder
+	for i := 0; b.Len() < 256*1024; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	data := b.String()
+	writeFiles(t, src, map[string]string{"sub/a.txt": data})
+	for _, dest := range []string{"--compare-dest", "--copy-dest", "--link-dest"} {
+		t.Run(fmt.Sprintf("%s/level%d", dest, differs), func(t *testing.T) {
+			dst := t.TempDir()
+			writeFiles(t, dst, map[string]string{"base/sub/a.txt": data + "old\n"})
+			old := mtime.Add(-time.Hour)
+			if err := os.Chtimes(filepath.Join(dst, "base", "sub", "a.txt"), old, old); err != nil {
+				t.Fatal(err)
+			}
+			stats, err := receive(t, "-rp "+dest+"=base", src, dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.MatchedData < int64(len(data))/2 {
+				t.Errorf("matched %d of %d bytes, want the basis file to be used", stats.MatchedData, len(data))
+			}
+			checkFiles(t, dst, map[string]string{
+				"base/sub/a.txt": data + "old\n",
+				"sub/a.txt":      data,
+			})
+		})
+	}
+
+	// Basis directories outside of the destination are refused instead of
+	// being resolved inside of it.
+	for _, dir := range []string{"..", "../base", "base/../../base"} {
+		t.Run("--link-dest="+dir, func(t *testing.T) {
+			dst := t.TempDir()
+			_, err := receive(t, "-r --link-dest="+dir, src, dst)
+			if got, want := rsync.ExitCode(err), int(rsync.RERR_SYNTAX); got != want {
+				t.Errorf("exit code %d (%v), want %d", got, err, want)
+			}
+			checkFiles(t, dst, map[string]string{})
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package rsyncreceiver
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+// destName returns the name of a file returned by Files.List relative to the
+// destination, in the same form as the names in the received file list.
+func (rt *Transfer) destName(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if dest := strings.Trim(rt.Dest, "/"); dest != "" {
+		name = strings.TrimPrefix(strings.TrimPrefix(name, dest), "/")
+	}
+	return filepath.Clean(name)
+}
+
+func (rt *Transfer) backupDir() string {
+	if rt.Opts.BackupDir == "" {
+		return ""
+	}
+	// Like all other names, the backup directory is relative to the
+	// destination, even when specified as an absolute path, and cannot
+	// reach outside of it.
+	return strings.TrimPrefix(filepath.Clean("/"+rt.Opts.BackupDir), "/")
+}
+
+// rsync/backup.c:get_backup_name
+func (rt *Transfer) backupName(name string) string {
+	if dir := rt.backupDir(); dir != "" {
+		return filepath.Join(dir, name) + rt.Opts.BackupSuffix
+	}
+	return name + rt.Opts.BackupSuffix
+}
+
+// isBackupFile reports whether name refers to a previously made backup, which
+// must be protected from deletion (rsync adds a “P *~” filter rule for this).
+func (rt *Transfer) isBackupFile(name string) bool {
+	if dir := rt.backupDir(); dir != "" {
+		return name == dir || strings.HasPrefix(name, dir+"/")
+	}
+	return rt.Opts.BackupSuffix != "" && strings.HasSuffix(name, rt.Opts.BackupSuffix)
+}
+
+// rsync/backup.c:make_backup
+func (rt *Transfer) makeBackup(name string) error {
+	st, in, err := rt.readFile(&utils.SenderFile{
+		WPath:   name,
+		Regular: true,
+	})
+	if err != nil {
+		// There is no old version to back up.
+		return nil
+	}
+	defer in.Close()
+
+	if !st.Mode().IsRegular() {
+		return nil
+	}
+
+	backup := &utils.ReceiverFile{
+		Name:    rt.backupName(name),
+		Length:  st.Size(),
+		ModTime: st.ModTime(),
+		Mode:    utils.ModeFromFileMode(st.Mode()),
+		Reader:  in,
+	}
+	if _, err := rt.putFile(backup); err != nil {
+		return rsync.Errorf(rsync.RERR_FILEIO, "backup of %s failed: %w", name, err)
+	}
+	rt.Logger.Debug("backed up", "file", name, "backup", backup.Name)
+	return nil
+}
@@ -0,0 +1,103 @@
+package rsyncreceiver_test
+
+import "testing"
+
+func TestBackup(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"changed.txt":     "new content",
+		"sub/changed.txt": "new content",
+		"same.txt":        "same",
+	})
+	existing := map[string]string{
+		"changed.txt":     "old",
+		"sub/changed.txt": "old",
+		"same.txt":        "same",
+		"extra.txt":       "extra",
+	}
+
+	for _, tt := range []struct {
+		args string
+		want map[string]string // in addition to the source files
+	}{
+		{"-r -b", map[string]string{
+			"changed.txt~":     "old",
+			"sub/changed.txt~": "old",
+			"extra.txt":        "extra",
+		}},
+		{"-r -b --suffix=.bak", map[string]string{
+			"changed.txt.bak":     "old",
+			"sub/changed.txt.bak": "old",
+			"extra.txt":           "extra",
+		}},
+		{"-r --backup-dir=old", map[string]string{
+			"old/changed.txt":     "old",
+			"old/sub/changed.txt": "old",
+			"extra.txt":           "extra",
+		}},
+		// The backup directory cannot reach outside of the destination.
+		{"-r --backup-dir=../old", map[string]string{
+			"old/changed.txt":     "old",
+			"old/sub/changed.txt": "old",
+			"extra.txt":           "extra",
+		}},
+		{"-r --backup-dir=old --suffix=.1", map[string]string{
+			"old/changed.txt.1":     "old",
+			"old/sub/changed.txt.1": "old",
+			"extra.txt":             "extra",
+		}},
+		// Deleted files are backed up, too, and backups are not deleted.
+		{"-r -b --delete", map[string]string{
+			"changed.txt~":     "old",
+			"sub/changed.txt~": "old",
+			"extra.txt~":       "extra",
+		}},
+		{"-r --backup-dir=old --delete", map[string]string{
+			"old/changed.txt":     "old",
+			"old/sub/changed.txt": "old",
+			"old/extra.txt":       "extra",
+		}},
+		// Dry runs make no backups.
+		{"-r -b -n --delete", map[string]string{
+			"changed.txt":     "old",
+			"sub/changed.txt": "old",
+			"extra.txt":       "extra",
+		}},
+	} {
+		t.Run(tt.args, func(t *testing.T) {
+			dst := t.TempDir()
+			writeFiles(t, dst, existing)
+			if _, err := receive(t, tt.args, src, dst); err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]string{
+				"changed.txt":     "new content",
+				"sub/changed.txt": "new content",
+				"same.txt":        "same",
+			}
+			for name, content := range tt.want {
+				want[name] = content
+			}
+			checkFiles(t, dst, want)
+		})
+	}
+
+	// A second backup replaces the first one.
+	dst := t.TempDir()
+	writeFiles(t, dst, existing)
+	if _, err := receive(t, "-r -b", src, dst); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, src, map[string]string{"changed.txt": "newer content"})
+	if _, err := receive(t, "-r -b", src, dst); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, dst, map[string]string{
+		"changed.txt":      "newer content",
+		"changed.txt~":     "new content",
+		"sub/changed.txt":  "new content",
+		"sub/changed.txt~": "old",
+		"same.txt":         "same",
+		"extra.txt":        "extra",
+	})
+}
@@ -0,0 +1,69 @@
+package rsyncreceiver
+
+import (
+	"os"
+
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+// rsync/main.c:do_server_recv
+//
+// checkCapabilities refuses a transfer which needs operations that
+// rt.Capabilities do not permit, before the file system is accessed.
+func (rt *Transfer) checkCapabilities() error {
+	c := rt.Capabilities
+	if rt.listOnly() || rt.Opts.DryRun {
+		return nil
+	}
+	if !c.CanWrite() {
+		return &utils.PermissionError{Op: "write"}
+	}
+	if rt.Opts.DeleteMode && !c.CanDelete() {
+		return &utils.PermissionError{Op: "delete"}
+	}
+	metadata := rt.Opts.PreservePerms || rt.Opts.PreserveTimes ||
+		rt.Opts.PreserveUid || rt.Opts.PreserveGid
+	if metadata && !c.CanMetadata() {
+		return &utils.PermissionError{Op: "change the metadata of"}
+	}
+	return nil
+}
+
+// The following methods guard each access to rt.Files. Without read access,
+// existing files are treated like missing files, i.e. they are neither used
+// as basis files nor skipped when up to date.
+
+func (rt *Transfer) readFile(sf *utils.SenderFile) (os.FileInfo, utils.ReaderAtCloser, error) {
+	if !rt.Capabilities.CanRead() {
+		return nil, nil, &utils.PermissionError{Op: "read", Name: sf.WPath}
+	}
+	return rt.Files.Read(sf)
+}
+
+func (rt *Transfer) listFiles(dir string) ([]os.FileInfo, error) {
+	if !rt.Capabilities.CanRead() {
+		return nil, &utils.PermissionError{Op: "list", Name: dir}
+	}
+	return rt.Files.List(dir)
+}
+
+func (rt *Transfer) putFile(f *utils.ReceiverFile) (int64, error) {
+	if !rt.Capabilities.CanWrite() {
+		return 0, &utils.PermissionError{Op: "write", Name: f.Name}
+	}
+	return rt.Files.Put(f)
+}
+
+func (rt *Transfer) deleteFile(deleter utils.Deleter, f *utils.ReceiverFile) error {
+	if !rt.Capabilities.CanDelete() {
+		return &utils.PermissionError{Op: "delete", Name: f.Name}
+	}
+	return deleter.Delete(f.Name)
+}
+
+func (rt *Transfer) removeFiles(retained []*utils.ReceiverFile) error {
+	if !rt.Capabilities.CanDelete() {
+		return &utils.PermissionError{Op: "delete"}
+	}
+	return rt.Files.Remove(retained)
+}
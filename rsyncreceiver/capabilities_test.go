@@ -0,0 +1,125 @@
+package rsyncreceiver_test
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/picosh/go-rsync-receiver/localfs"
+	"github.com/picosh/go-rsync-receiver/rsyncclient"
+	"github.com/picosh/go-rsync-receiver/rsyncopts"
+	"github.com/picosh/go-rsync-receiver/rsyncreceiver"
+	"github.com/picosh/go-rsync-receiver/rsyncsender"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+// transfer runs rsync args between the client directory local and a server
+// directory remote whose capabilities are restricted to caps. When pushing,
+// options apply to the server’s receiver. It returns the client’s stderr and
+// error.
+func transfer(t *testing.T, args string, caps *utils.Capabilities, local, remote string, push bool, options ...rsyncreceiver.Option) (string, error) {
+	t.Helper()
+	pc, err := rsyncopts.ParseArguments(strings.Fields(args), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := pc.Options
+	var names []string
+	if push {
+		opts.SetSender()
+		names = []string{"."}
+	}
+	spc, err := rsyncopts.ParseArguments(rsyncclient.ServerArgs(opts, []string{"."}), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	clientConn, serverConn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer serverConn.Close()
+		if push {
+			options = append(options, rsyncreceiver.WithCapabilities(caps))
+			rsyncreceiver.ClientRun(logger, spc.Options, serverConn, localfs.New(remote), nil, true, options...)
+		} else {
+			rsyncsender.ClientRun(logger, spc.Options, serverConn, localfs.New(remote), spc.RemainingArgs[1:], true,
+				rsyncsender.WithCapabilities(caps))
+		}
+	}()
+	var stderr bytes.Buffer
+	_, err = rsyncclient.Run(logger, opts, clientConn, localfs.New(local), names,
+		rsyncclient.WithOutput(io.Discard, &stderr))
+	clientConn.Close()
+	<-done
+	return stderr.String(), err
+}
+
+func TestCapabilities(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "new.txt"), []byte("new content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		args    string
+		caps    utils.Capabilities
+		refused string
+	}{
+		{"ReadOnly", "-r", utils.Capabilities{AllowRead: true}, "to write files"},
+		{"NoDelete", "-r --delete", utils.Capabilities{AllowRead: true, AllowWrite: true}, "to delete files"},
+		{"NoMetadata", "-a", utils.Capabilities{AllowRead: true, AllowWrite: true}, "to change the metadata of files"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dst, "old.txt"), []byte("old"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			stderr, err := transfer(t, tt.args, &tt.caps, src, dst, true)
+			if err == nil || !strings.Contains(stderr, tt.refused) {
+				t.Errorf("err = %v, stderr = %q, want %q", err, stderr, tt.refused)
+			}
+			if _, err := os.Stat(filepath.Join(dst, "new.txt")); err == nil {
+				t.Errorf("new.txt was written")
+			}
+			if _, err := os.Stat(filepath.Join(dst, "old.txt")); err != nil {
+				t.Errorf("old.txt: %v", err)
+			}
+		})
+	}
+
+	t.Run("WriteOnly", func(t *testing.T) {
+		dst := t.TempDir()
+		// Without read access, the existing file must not be used as the
+		// basis of a delta transfer, but is overwritten.
+		if err := os.WriteFile(filepath.Join(dst, "new.txt"), []byte("new stale!!"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		caps := &utils.Capabilities{AllowWrite: true}
+		if stderr, err := transfer(t, "-r", caps, src, dst, true); err != nil {
+			t.Fatalf("%v (stderr: %q)", err, stderr)
+		}
+		if got, err := os.ReadFile(filepath.Join(dst, "new.txt")); err != nil || string(got) != "new content" {
+			t.Errorf("new.txt = %q, %v", got, err)
+		}
+	})
+
+	t.Run("NoRead", func(t *testing.T) {
+		dst := t.TempDir()
+		caps := &utils.Capabilities{AllowWrite: true}
+		stderr, err := transfer(t, "-r", caps, dst, src, false)
+		if err == nil || !strings.Contains(stderr, "to read files") {
+			t.Errorf("err = %v, stderr = %q", err, stderr)
+		}
+		if _, err := os.Stat(filepath.Join(dst, "new.txt")); err == nil {
+			t.Errorf("new.txt was sent")
+		}
+	})
+}
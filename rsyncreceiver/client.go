@@ -5,6 +5,7 @@ import (
 	"io"
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/picosh/go-rsync-receiver/rsync"
 	"github.com/picosh/go-rsync-receiver/rsyncopts"
@@ -13,11 +14,28 @@ import (
 	"github.com/picosh/go-rsync-receiver/utils"
 )
 
-func ClientRun(logger *slog.Logger, opts *rsyncopts.Options, conn io.ReadWriter, filesystem utils.FS, paths []string, negotiate bool) error {
+func ClientRun(logger *slog.Logger, opts *rsyncopts.Options, conn io.ReadWriter, filesystem utils.FS, paths []string, negotiate bool, options ...Option) error {
 	var err error
 
+	var co clientOptions
+	for _, opt := range options {
+		opt(&co)
+	}
+
+	// rsync/io.c:check_timeout
+	timeout := time.Duration(opts.IOTimeoutSeconds()) * time.Second
+	conn = rsyncwire.WithTimeout(conn, timeout)
+
 	crd, cwr := rsyncwire.CounterPair(conn, conn)
 
+	// rsync/io.c:sleep_for_bwlimit
+	if co.limiter != nil {
+		rsyncwire.LimitPair(co.limiter, crd, cwr)
+	} else if bwlimit := opts.BwLimit(); bwlimit > 0 {
+		// Like rsync, --bwlimit only limits the data we send.
+		cwr.W = rsyncwire.NewLimiter(int64(bwlimit) * 1024).Writer(cwr.W)
+	}
+
 	const sessionChecksumSeed = 666
 
 	c := &rsyncwire.Conn{
@@ -25,8 +43,9 @@ func ClientRun(logger *slog.Logger, opts *rsyncopts.Options, conn io.ReadWriter,
 		Writer: cwr,
 	}
 
+	var remoteProtocol int32
 	if negotiate {
-		remoteProtocol, err := c.ReadInt32()
+		remoteProtocol, err = c.ReadInt32()
 		if err != nil {
 			return err
 		}
@@ -42,32 +61,28 @@ func ClientRun(logger *slog.Logger, opts *rsyncopts.Options, conn io.ReadWriter,
 
 	// Switch to multiplexing protocol, but only for server-side transmissions.
 	// Transmissions received from the client are not multiplexed.
-	mpx := &rsyncwire.MultiplexWriter{Writer: c.Writer}
+	// The generator flushes the buffered output after each file request.
+	mpx := &rsyncwire.BufferedMultiplexWriter{Writer: c.Writer}
 	c.Writer = mpx
+	if timeout > 0 {
+		stop := mpx.Keepalive(timeout, min(remoteProtocol, rsync.ProtocolVersion) >= 30)
+		defer stop()
+	}
 
 	defer func() {
 		if err != nil {
-			mpx.WriteMsg(rsyncwire.MsgError, fmt.Appendf(nil, "gokr-rsync [receiver]: %v\n", err))
+			// rsync/io.c:noop_io_until_death
+			//
+			// The client might still be sending (e.g. its file list when a
+			// limit was exceeded), which we discard so that it does not
+			// block receiving the error.
+			go io.Copy(io.Discard, conn)
+			rsyncwire.SendError(mpx, fmt.Sprintf("gokr-rsync [receiver]: %v\n", err))
 		}
 	}()
 
 	rt := &Transfer{
-		Opts: &TransferOpts{
-			DryRun: opts.DryRun(),
-
-			DeleteMode:       opts.DeleteMode(),
-			PreserveGid:      opts.PreserveGid(),
-			PreserveUid:      opts.PreserveUid(),
-			PreserveLinks:    opts.PreserveLinks(),
-			PreservePerms:    opts.PreservePerms(),
-			PreserveDevices:  opts.PreserveDevices(),
-			PreserveSpecials: opts.PreserveSpecials(),
-			PreserveTimes:    opts.PreserveMTimes(),
-			IgnoreTimes:      opts.IgnoreTimes(),
-			SizeOnly:         opts.SizeOnly(),
-			AlwaysChecksum:   opts.AlwaysChecksum(),
-			// TODO: PreserveHardlinks: opts.PreserveHardlinks,
-		},
+		Opts: NewTransferOpts(opts),
 		Dest: "/",
 		// TODO: what is Env used for and can we get rid of it?
 		Env: Osenv{
@@ -78,28 +93,63 @@ func ClientRun(logger *slog.Logger, opts *rsyncopts.Options, conn io.ReadWriter,
 		Conn: c,
 		Seed: sessionChecksumSeed,
 
-		Files: filesystem,
+		RemoteProtocol: remoteProtocol,
+		Observer:       co.observer,
+		LogSink:        co.logSink,
+		LogFormat:      co.logFormat,
+
+		Files:        filesystem,
+		Capabilities: co.capabilities,
+		Policy:       co.policy,
+		owner:        co.owner,
 
 		Logger: logger,
 	}
 
-	if opts.DeleteMode() {
+	rt.Opts.DaemonFilters = co.filters
+	if co.maxFileSize > 0 && (rt.Opts.MaxSize < 0 || co.maxFileSize < rt.Opts.MaxSize) {
+		rt.Opts.MaxSize = co.maxFileSize
+	}
+	rt.Opts.Limits = co.limits
+
+	// rsync/exclude.c:recv_filter_list
+	if opts.DeleteMode() && !opts.DeleteExcluded() {
 		// receive the exclusion list (openrsync’s is always empty)
 		exclusionList, err := rsyncsender.RecvFilterList(c)
 		if err != nil {
 			return err
 		}
 		logger.Debug("exclusion list read", "filters", exclusionList.Filters)
+		rt.Opts.Filters = exclusionList
 	}
 
 	// receive file list
 	logger.Debug("receiving file list")
+	flistStart := crd.BytesRead
 	fileList, err := rt.ReceiveFileList()
 	if err != nil {
 		return err
 	}
+	rt.stats.FileListSize = crd.BytesRead - flistStart
+	if o := co.owner; o != nil {
+		for _, f := range fileList {
+			if o.uid >= 0 {
+				f.Uid = o.uid
+			}
+			if o.gid >= 0 {
+				f.Gid = o.gid
+			}
+		}
+	}
 	logger.Debug("received names", "files", fileList)
 	stats, err := rt.Do(c, fileList, true)
+	if stats != nil {
+		stats.Read = crd.BytesRead
+		stats.Written = cwr.BytesWritten
+		if co.stats != nil {
+			*co.stats = *stats
+		}
+	}
 	if err != nil {
 		return err
 	}
@@ -107,3 +157,41 @@ func ClientRun(logger *slog.Logger, opts *rsyncopts.Options, conn io.ReadWriter,
 	logger.Debug("stats", "stats", stats)
 	return nil
 }
+
+// NewTransferOpts returns the TransferOpts corresponding to opts.
+func NewTransferOpts(opts *rsyncopts.Options) *TransferOpts {
+	return &TransferOpts{
+		Verbose: opts.Verbose(),
+		DryRun:  opts.DryRun(),
+
+		DeleteMode:        opts.DeleteMode(),
+		DeleteBefore:      opts.DeleteBefore(),
+		DeleteDuring:      opts.DeleteDuring(),
+		DeleteDelay:       opts.DeleteDelay(),
+		DeleteAfter:       opts.DeleteAfter(),
+		DeleteExcluded:    opts.DeleteExcluded(),
+		PreserveGid:       opts.PreserveGid(),
+		PreserveUid:       opts.PreserveUid(),
+		PreserveLinks:     opts.PreserveLinks(),
+		PreservePerms:     opts.PreservePerms(),
+		PreserveDevices:   opts.PreserveDevices(),
+		PreserveSpecials:  opts.PreserveSpecials(),
+		PreserveTimes:     opts.PreserveMTimes(),
+		PreserveHardlinks: opts.PreserveHardLinks(),
+		IgnoreTimes:       opts.IgnoreTimes(),
+		SizeOnly:          opts.SizeOnly(),
+		AlwaysChecksum:    opts.AlwaysChecksum(),
+		MakeBackups:       opts.MakeBackups(),
+		BackupDir:         opts.BackupDir(),
+		BackupSuffix:      opts.BackupSuffix(),
+		BasisDirs:         opts.BasisDirs(),
+		AltDestType:       opts.AltDestType(),
+		FuzzyBasis:        opts.FuzzyBasis(),
+		MaxDelete:         opts.MaxDelete(),
+		MaxSize:           opts.MaxSize(),
+		MinSize:           opts.MinSize(),
+		ItemizeChanges:    opts.ItemizeChanges(),
+		OutFormat:         opts.StdoutFormat(),
+		HumanReadable:     opts.HumanReadable(),
+	}
+}
@@ -0,0 +1,260 @@
+package rsyncreceiver
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+// listDest returns the files in the destination as they were before the
+// transfer started, sorted by name. The destination is listed only once per
+// transfer.
+func (rt *Transfer) listDest() ([]*utils.ReceiverFile, error) {
+	if rt.destFiles != nil {
+		return rt.destFiles, nil
+	}
+	existing, err := rt.listFiles(rt.Dest)
+	// A destination which does not exist yet has nothing to delete.
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	destFiles := make([]*utils.ReceiverFile, 0, len(existing))
+	for _, info := range existing {
+		destFiles = append(destFiles, &utils.ReceiverFile{
+			Name:    rt.destName(info.Name()),
+			Length:  info.Size(),
+			ModTime: info.ModTime(),
+			Mode:    utils.ModeFromFileMode(info.Mode()),
+		})
+	}
+	utils.SortFileList(destFiles)
+	rt.destFiles = destFiles
+	return rt.destFiles, nil
+}
+
+// subtree returns all files below dir, which are stored contiguously in the
+// sorted file list.
+func subtree(fileList []*utils.ReceiverFile, dir string) []*utils.ReceiverFile {
+	prefix := dir + "/"
+	if dir == "." {
+		prefix = ""
+	}
+	start := sort.Search(len(fileList), func(i int) bool {
+		return fileList[i].Name >= prefix
+	})
+	end := start
+	for end < len(fileList) && strings.HasPrefix(fileList[end].Name, prefix) {
+		end++
+	}
+	return fileList[start:end]
+}
+
+// rsync/delete.c:delete_item
+//
+// deleteItem marks f for deletion, unless the --max-delete limit has been
+// reached or the Policy refuses. It returns false if f is retained.
+func (rt *Transfer) deleteItem(f *utils.ReceiverFile) (bool, error) {
+	if rt.Opts.MaxDelete >= 0 && rt.deletions >= rt.Opts.MaxDelete {
+		rt.skippedDeletes++
+		return false, nil
+	}
+	if ok, err := rt.consult(OpDelete, f); !ok || err != nil {
+		return false, err
+	}
+	if rt.Opts.MakeBackups && !rt.Opts.DryRun && f.FileMode().IsRegular() {
+		if err := rt.makeBackup(f.Name); err != nil {
+			return false, err
+		}
+	}
+	if deleter, ok := rt.Files.(utils.Deleter); ok && !rt.Opts.DryRun {
+		if err := rt.deleteFile(deleter, f); err != nil {
+			if f.FileMode().IsDir() && errors.Is(err, syscall.ENOTEMPTY) {
+				// e.g. it contains backups of the deleted files
+				return false, rt.info(fmt.Sprintf("cannot delete non-empty directory: %s\n", f.Name))
+			}
+			rt.Logger.Error("delete failed", "file", f, "err", err)
+			return false, rt.xferError(fmt.Sprintf("delete_file: %v\n", err))
+		}
+	}
+	if err := rt.logDelete(f); err != nil {
+		return false, err
+	}
+	rt.deletions++
+	rt.stats.DeletedFiles.Add(f.FileMode())
+	rt.obs().OnDelete(observed(f))
+	if rt.deleted == nil {
+		rt.deleted = make(map[string]bool)
+	}
+	rt.deleted[f.Name] = true
+	return true, nil
+}
+
+// rsync/delete.c:delete_dir_contents
+//
+// deleteDirContents deletes the contents of the extraneous directory dir,
+// deepest entries first. It returns false if any entry was retained, in
+// which case dir itself cannot be deleted.
+func (rt *Transfer) deleteDirContents(existing []*utils.ReceiverFile, dir *utils.ReceiverFile) (bool, error) {
+	retained := make(map[string]bool)
+	retain := func(name string) {
+		for parent := filepath.Dir(name); ; parent = filepath.Dir(parent) {
+			retained[parent] = true
+			if parent == dir.Name || parent == "." {
+				break
+			}
+		}
+	}
+	for _, f := range slices.Backward(subtree(existing, dir.Name)) {
+		if rt.deleted[f.Name] {
+			continue
+		}
+		if retained[f.Name] || rt.isBackupFile(f.Name) || rt.protected(f.Name, f.FileMode().IsDir()) {
+			retain(f.Name)
+			continue
+		}
+		deleted, err := rt.deleteItem(f)
+		if err != nil {
+			return false, err
+		}
+		if !deleted {
+			retain(f.Name)
+		}
+	}
+	return !retained[dir.Name], nil
+}
+
+// protected reports whether name must not be deleted: it is protected by the
+// client’s filter rules or excluded by the server’s.
+func (rt *Transfer) protected(name string, isDir bool) bool {
+	return rt.Opts.Filters.Protected(name, isDir) || rt.Opts.DaemonFilters.Excluded(name, isDir)
+}
+
+// rsync/generator.c:delete_in_dir
+//
+// deleteInDir deletes the files in dir (a directory of the file list) which
+// are not part of the file list. If dir is nil, the extraneous files of all
+// directories in the file list are deleted.
+func (rt *Transfer) deleteInDir(fileList []*utils.ReceiverFile, dir *utils.ReceiverFile) error {
+	if rt.IOErrors > 0 {
+		if rt.warnedIOError {
+			return nil
+		}
+		rt.warnedIOError = true
+		return rt.info("IO error encountered -- skipping file deletion\n")
+	}
+
+	existing, err := rt.listDest()
+	if err != nil {
+		return err
+	}
+
+	inScope := func(parent string) bool {
+		if dir != nil {
+			return parent == dir.Name
+		}
+		i := sort.Search(len(fileList), func(i int) bool {
+			return fileList[i].Name >= parent
+		})
+		return i < len(fileList) && fileList[i].Name == parent && fileList[i].FileMode().IsDir()
+	}
+
+	before := len(rt.deleted)
+	for _, f := range existing {
+		if rt.deleted[f.Name] || f.Name == "." {
+			continue
+		}
+		if !inScope(filepath.Dir(f.Name)) {
+			continue
+		}
+		if utils.FindInFileList(fileList, f.Name) {
+			continue
+		}
+		isDir := f.FileMode().IsDir()
+		if rt.isBackupFile(f.Name) || rt.protected(f.Name, isDir) {
+			rt.Logger.Debug("protected from deletion", "file", f.Name)
+			continue
+		}
+		if isDir {
+			empty, err := rt.deleteDirContents(existing, f)
+			if err != nil {
+				return err
+			}
+			if !empty {
+				continue
+			}
+		}
+		if _, err := rt.deleteItem(f); err != nil {
+			return err
+		}
+	}
+
+	if _, ok := rt.Files.(utils.Deleter); ok || len(rt.deleted) == before || rt.Opts.DryRun {
+		return nil
+	}
+	if dir != nil {
+		// Files.Remove compares the whole tree, so instead of removing the
+		// files of each directory, all are removed once the transfer is done
+		// (see removeDeleted).
+		rt.removePending = true
+		return nil
+	}
+	return rt.removeFiles(rt.retainedFiles(fileList, existing))
+}
+
+// removeDeleted removes the files which --delete-during marked for deletion
+// from a file system without utils.Deleter support.
+func (rt *Transfer) removeDeleted(fileList []*utils.ReceiverFile) error {
+	if !rt.removePending {
+		return nil
+	}
+	rt.removePending = false
+	existing, err := rt.listDest()
+	if err != nil {
+		return err
+	}
+	return rt.removeFiles(rt.retainedFiles(fileList, existing))
+}
+
+// retainedFiles returns the list of files which Files.Remove must keep: the
+// file list, all pre-existing files which were not deleted, and the backups
+// of replaced or deleted files (including their parent directories).
+func (rt *Transfer) retainedFiles(fileList, existing []*utils.ReceiverFile) []*utils.ReceiverFile {
+	keep := slices.Clone(fileList)
+	retainBackup := func(f *utils.ReceiverFile) {
+		if !rt.Opts.MakeBackups || !f.FileMode().IsRegular() {
+			return
+		}
+		name := rt.backupName(f.Name)
+		keep = append(keep, &utils.ReceiverFile{Name: name, Mode: f.Mode})
+		for parent := filepath.Dir(name); parent != "." && parent != "/"; parent = filepath.Dir(parent) {
+			keep = append(keep, &utils.ReceiverFile{Name: parent, Mode: rsync.S_IFDIR})
+		}
+	}
+	for _, f := range existing {
+		if rt.deleted[f.Name] || utils.FindInFileList(fileList, f.Name) {
+			// Deleted or replaced files are backed up.
+			retainBackup(f)
+		}
+		if !rt.deleted[f.Name] {
+			keep = append(keep, f)
+		}
+	}
+	utils.SortFileList(keep)
+	return keep
+}
+
+// rsync/main.c:do_recv (deletion limit handling)
+func (rt *Transfer) maxDeleteError() error {
+	if rt.skippedDeletes == 0 {
+		return nil
+	}
+	return rsync.Errorf(rsync.RERR_DEL_LIMIT, "Deletions stopped due to --max-delete limit (%d skipped)", rt.skippedDeletes)
+}
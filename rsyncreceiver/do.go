@@ -2,6 +2,7 @@ package rsyncreceiver
 
 import (
 	"context"
+	"time"
 
 	"github.com/picosh/go-rsync-receiver/rsyncstats"
 	"github.com/picosh/go-rsync-receiver/rsyncwire"
@@ -9,27 +10,61 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
-func (rt *Transfer) deleteFiles(fileList []*utils.ReceiverFile) error {
-	if rt.IOErrors > 0 {
-		rt.Logger.Debug("IO error encountered, skipping file deletion")
-		return nil
-	}
-
-	return rt.Files.Remove(fileList)
+// deleteBefore reports whether extraneous files are deleted before the
+// transfer, which is the default for protocol versions before 30.
+func (rt *Transfer) deleteBefore() bool {
+	return rt.Opts.DeleteBefore ||
+		(!rt.Opts.DeleteDuring && !rt.Opts.DeleteDelay && !rt.Opts.DeleteAfter)
 }
 
 // rsync/main.c:do_recv
 func (rt *Transfer) Do(c *rsyncwire.Conn, fileList []*utils.ReceiverFile, noReport bool) (*rsyncstats.TransferStats, error) {
-	if rt.Opts.DeleteMode {
-		if err := rt.deleteFiles(fileList); err != nil {
+	start := time.Now()
+	rt.stats.DryRun = rt.Opts.DryRun
+	var files []rsyncstats.File
+	for _, f := range fileList {
+		rt.stats.Files.Add(f.FileMode())
+		if !f.FileMode().IsDir() {
+			rt.stats.Size += f.Length
+		}
+		if rt.Observer != nil {
+			files = append(files, observed(f))
+		}
+	}
+	rt.obs().OnFileList(files)
+
+	if err := rt.checkCapabilities(); err != nil {
+		return nil, err
+	}
+	if err := rt.checkBasisDirs(); err != nil {
+		return nil, err
+	}
+
+	if rt.Opts.DeleteMode && rt.deleteBefore() {
+		if err := rt.deleteInDir(fileList, nil); err != nil {
 			return nil, err
 		}
 	}
 
+	if rt.Opts.PreserveHardlinks {
+		rt.initHardLinks(fileList)
+	}
+
 	ctx := context.Background()
 	eg, ctx := errgroup.WithContext(ctx)
 	eg.Go(func() error {
-		return rt.GenerateFiles(fileList)
+		// Ensure we don’t block on the generator when the receiver returns an
+		// error (e.g. when writing a file failed).
+		errChan := make(chan error)
+		go func() {
+			errChan <- rt.GenerateFiles(fileList)
+		}()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errChan:
+			return err
+		}
 	})
 	eg.Go(func() error {
 		// Ensure we don’t block on the receiver when the generator returns an
@@ -49,46 +84,65 @@ func (rt *Transfer) Do(c *rsyncwire.Conn, fileList []*utils.ReceiverFile, noRepo
 		return nil, err
 	}
 
-	var stats *rsyncstats.TransferStats
+	if rt.Opts.PreserveHardlinks {
+		if err := rt.doHardLinks(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := rt.removeDeleted(fileList); err != nil {
+		return nil, err
+	}
+
+	if rt.Opts.DeleteMode && (rt.Opts.DeleteAfter || rt.Opts.DeleteDelay) {
+		if err := rt.deleteInDir(fileList, nil); err != nil {
+			return nil, err
+		}
+	}
+
 	if !noReport {
-		var err error
-		stats, err = report(c)
-		rt.Logger.Debug("report", "stats", stats)
+		err := rt.report(c)
+		rt.Logger.Debug("report", "stats", rt.stats)
 		if err != nil {
 			return nil, err
 		}
 	}
+	rt.stats.Elapsed = time.Since(start)
+	stats := rt.stats
 
 	// send final goodbye message
 	if err := c.WriteInt32(-1); err != nil {
 		return nil, err
 	}
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
 
-	return stats, nil
+	return &stats, rt.maxDeleteError()
 }
 
-// rsync/main.c:report
-func report(c *rsyncwire.Conn) (*rsyncstats.TransferStats, error) {
-	// read statistics:
+// rsync/main.c:handle_stats
+func (rt *Transfer) report(c *rsyncwire.Conn) error {
+	// read statistics (from the sender’s perspective):
 	// total bytes read (from network connection)
 	read, err := c.ReadInt64()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	// total bytes written (to network connection)
 	written, err := c.ReadInt64()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	// total size of files
 	size, err := c.ReadInt64()
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	return &rsyncstats.TransferStats{
-		Read:    read,
-		Written: written,
-		Size:    size,
-	}, nil
+	rt.stats.Read = read
+	rt.stats.Written = written
+	rt.stats.Size = size
+	// The file list build and transfer times follow from protocol 29 on,
+	// but rsync.ProtocolVersion is 27.
+	return nil
 }
@@ -1,12 +1,12 @@
 package rsyncreceiver
 
 import (
-	"fmt"
 	"io"
 	"path/filepath"
 	"time"
 
 	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/rsyncwire"
 	"github.com/picosh/go-rsync-receiver/utils"
 )
 
@@ -41,7 +41,7 @@ func (rt *Transfer) receiveFileEntry(flags uint16, last *utils.ReceiverFile) (*u
 	const PATH_MAX = 4096
 	if l2 >= PATH_MAX-l1 {
 		const lastname = ""
-		return nil, fmt.Errorf("overflow: flags=0x%x l1=%d l2=%d lastname=%s",
+		return nil, rsync.Errorf(rsync.RERR_PROTOCOL, "overflow: flags=0x%x l1=%d l2=%d lastname=%s",
 			flags, l1, l2, lastname)
 	}
 	b := make([]byte, l1+l2)
@@ -137,6 +137,24 @@ func (rt *Transfer) receiveFileEntry(flags uint16, last *utils.ReceiverFile) (*u
 		f.LinkTarget = string(b)
 	}
 
+	// protocol < 28 implies XMIT_HAS_IDEV_DATA for regular files
+	if rt.Opts.PreserveHardlinks && mode == rsync.S_IFREG {
+		if flags&rsync.XMIT_SAME_DEV != 0 {
+			f.Dev = last.Dev
+		} else {
+			dev, err := rt.Conn.ReadInt64()
+			if err != nil {
+				return nil, err
+			}
+			f.Dev = dev
+		}
+		inode, err := rt.Conn.ReadInt64()
+		if err != nil {
+			return nil, err
+		}
+		f.Inode = inode
+	}
+
 	return f, nil
 }
 
@@ -144,6 +162,11 @@ func (rt *Transfer) receiveFileEntry(flags uint16, last *utils.ReceiverFile) (*u
 func (rt *Transfer) ReceiveFileList() ([]*utils.ReceiverFile, error) {
 	lastFileEntry := new(utils.ReceiverFile)
 	var fileList []*utils.ReceiverFile
+	counter := &rsyncwire.CountingReader{R: rt.Conn.Reader}
+	if rt.Opts.Limits.MaxFileListSize > 0 {
+		rt.Conn.Reader = counter
+		defer func() { rt.Conn.Reader = counter.R }()
+	}
 	for {
 		b, err := rt.Conn.ReadByte()
 		if err != nil {
@@ -165,6 +188,9 @@ func (rt *Transfer) ReceiveFileList() ([]*utils.ReceiverFile, error) {
 		rt.Logger.Debug("recv_file_list", "file", f.Name, "length", f.Length, "mode", f.Mode, "uid", f.Uid, "gid", f.Gid, "flags", flags)
 
 		fileList = append(fileList, f)
+		if err := rt.Opts.Limits.checkFileList(len(fileList), counter.BytesRead); err != nil {
+			return nil, err
+		}
 	}
 
 	utils.SortFileList(fileList)
@@ -0,0 +1,221 @@
+package rsyncreceiver
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+// dirList returns the (non-recursive) contents of the destination directory
+// dir, like rsync/generator.c:get_dirlist.
+func (rt *Transfer) dirList(dir string) ([]*utils.ReceiverFile, error) {
+	existing, err := rt.listDest()
+	if err != nil {
+		return nil, err
+	}
+	var list []*utils.ReceiverFile
+	for _, f := range subtree(existing, dir) {
+		if filepath.Dir(f.Name) == dir {
+			list = append(list, f)
+		}
+	}
+	return list, nil
+}
+
+// basisDirList returns the (non-recursive) contents of dir in an alternate
+// basis directory, named like the basis files of tryDestsReg. A missing
+// directory is empty.
+func (rt *Transfer) basisDirList(dir string) ([]*utils.ReceiverFile, error) {
+	if list, ok := rt.basisDirLists[dir]; ok {
+		return list, nil
+	}
+	infos, err := rt.listFiles(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	var list []*utils.ReceiverFile
+	for _, info := range infos {
+		name := strings.TrimPrefix(info.Name(), "/")
+		if filepath.Dir(name) != dir {
+			continue
+		}
+		list = append(list, &utils.ReceiverFile{
+			Name:    name,
+			Length:  info.Size(),
+			ModTime: info.ModTime(),
+			Mode:    utils.ModeFromFileMode(info.Mode()),
+		})
+	}
+	if rt.basisDirLists == nil {
+		rt.basisDirLists = make(map[string][]*utils.ReceiverFile)
+	}
+	rt.basisDirLists[dir] = list
+	return list, nil
+}
+
+// rsync/util1.c:find_filename_suffix
+func findFilenameSuffix(fn string) string {
+	// One or more dots at the start aren’t a suffix.
+	fn = strings.TrimLeft(fn, ".")
+
+	// Ignore the ~ in a “foo~” filename.
+	hadTilde := false
+	if len(fn) > 1 && strings.HasSuffix(fn, "~") {
+		fn = fn[:len(fn)-1]
+		hadTilde = true
+	}
+
+	// Assume we don’t find a suffix.
+	suf := ""
+
+	// Find the last significant suffix.
+	for len(fn) > 1 {
+		dot := strings.LastIndexByte(fn, '.')
+		if dot <= 0 {
+			break
+		}
+		s := fn[dot:]
+		fn = fn[:dot]
+		switch {
+		case s == ".bak" || s == ".old" || s == ".orig":
+			continue
+		case len(s) > 2 && hadTilde && s[1] >= '0' && s[1] <= '9':
+			continue
+		}
+		suf = s
+		if len(s) == 1 {
+			break
+		}
+		// An all-digit suffix may not be that significant.
+		if strings.TrimLeft(s[1:], "0123456789") != "" {
+			return suf
+		}
+	}
+
+	return suf
+}
+
+// rsync/util1.c:fuzzy_distance
+func fuzzyDistance(s1, s2 string, upperLimit uint32) uint32 {
+	const unit = 1 << 16
+
+	// Check to see if the Levenshtein distance must be greater than the upper
+	// limit defined by the caller.
+	lenDiff := len(s1) - len(s2)
+	if lenDiff < 0 {
+		lenDiff = -lenDiff
+	}
+	if uint32(lenDiff) > upperLimit/unit {
+		return 0xFFFF*unit + 1
+	}
+
+	if len(s1) == 0 || len(s2) == 0 {
+		if len(s1) == 0 {
+			s1 = s2
+		}
+		var cost uint32
+		for i := 0; i < len(s1); i++ {
+			cost += uint32(s1[i])
+		}
+		return uint32(len(s1))*unit + cost
+	}
+
+	a := make([]uint32, len(s2))
+	for i2 := range a {
+		a[i2] = uint32(i2+1) * unit
+	}
+
+	for i1 := 0; i1 < len(s1); i1++ {
+		diag := uint32(i1) * unit
+		above := uint32(i1+1) * unit
+		for i2 := 0; i2 < len(s2); i2++ {
+			left := a[i2]
+			var cost uint32
+			if c := int32(s1[i1]) - int32(s2[i2]); c < 0 {
+				cost = uint32(unit - c)
+			} else if c > 0 {
+				cost = uint32(unit + c)
+			}
+			diagInc := diag + cost
+			leftInc := left + unit + uint32(s1[i1])
+			aboveInc := above + unit + uint32(s2[i2])
+			if left < above {
+				above = min(leftInc, diagInc)
+			} else {
+				above = min(aboveInc, diagInc)
+			}
+			a[i2] = above
+			diag = left
+		}
+	}
+
+	return a[len(s2)-1]
+}
+
+// rsync/generator.c:find_fuzzy
+//
+// findFuzzy looks for a file in the same destination directory as f (and, with
+// -yy, in the same directory of each alternate basis directory) with a
+// similar name, which serves as a basis file when f does not exist yet. It
+// returns the empty string if no suitable file was found.
+func (rt *Transfer) findFuzzy(f *utils.ReceiverFile) (string, error) {
+	dir := filepath.Dir(f.Name)
+	list, err := rt.dirList(dir)
+	if err != nil {
+		return "", err
+	}
+	lists := [][]*utils.ReceiverFile{list}
+	if rt.Opts.FuzzyBasis > 1 {
+		// -yy also looks in the alternate basis directories.
+		for _, basisDir := range rt.Opts.BasisDirs {
+			list, err := rt.basisDirList(basisName(basisDir, dir))
+			if err != nil {
+				return "", err
+			}
+			lists = append(lists, list)
+		}
+	}
+
+	candidate := func(fp *utils.ReceiverFile) bool {
+		return fp.FileMode().IsRegular() && fp.Length > 0
+	}
+
+	// Try to find an exact size+mtime match first.
+	for _, list := range lists {
+		for _, fp := range list {
+			if !candidate(fp) {
+				continue
+			}
+			if fp.Length == f.Length && fp.ModTime.Equal(f.ModTime) {
+				return fp.Name, nil
+			}
+		}
+	}
+
+	fname := filepath.Base(f.Name)
+	fnameSuf := findFilenameSuffix(fname)
+	var lowestDist uint32 = 25 << 16 // ignore a distance greater than 25
+	var lowest string
+	for _, list := range lists {
+		for _, fp := range list {
+			if !candidate(fp) {
+				continue
+			}
+			name := filepath.Base(fp.Name)
+			dist := fuzzyDistance(name, fname, lowestDist)
+			// Add some extra weight to how well the suffixes match unless
+			// we’ve already disqualified this file based on a heuristic.
+			if dist < 0xFFFF0000 {
+				dist = fuzzyDistance(findFilenameSuffix(name), fnameSuf, 0xFFFF0000)*10 + dist
+			}
+			if dist <= lowestDist {
+				lowestDist = dist
+				lowest = fp.Name
+			}
+		}
+	}
+	return lowest, nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/picosh/go-rsync-receiver/rsync"
 	"github.com/picosh/go-rsync-receiver/rsyncchecksum"
 	"github.com/picosh/go-rsync-receiver/rsynccommon"
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
 	"github.com/picosh/go-rsync-receiver/utils"
 )
 
@@ -15,17 +16,31 @@ import (
 func (rt *Transfer) GenerateFiles(fileList []*utils.ReceiverFile) error {
 	phase := 0
 	for idx, f := range fileList {
+		if rt.Opts.DeleteMode && rt.Opts.DeleteDuring && f.FileMode().IsDir() {
+			if err := rt.deleteInDir(fileList, f); err != nil {
+				return err
+			}
+		}
 		// TODO: use a copy of f with .Mode |= S_IWUSR for directories, so
 		// that we can create files within all directories.
 		if err := rt.recvGenerator(idx, f); err != nil {
 			return err
 		}
+		// Send each request right away, so that the sender works on it
+		// while we generate the next one (flushing is a no-op when nothing
+		// was requested, e.g. for up-to-date files).
+		if err := rt.Conn.Flush(); err != nil {
+			return err
+		}
 	}
 	phase++
 	rt.Logger.Debug("generateFiles", "phase", phase)
 	if err := rt.Conn.WriteInt32(-1); err != nil {
 		return err
 	}
+	if err := rt.Conn.Flush(); err != nil {
+		return err
+	}
 
 	// TODO: re-do any files that failed
 	phase++
@@ -33,6 +48,9 @@ func (rt *Transfer) GenerateFiles(fileList []*utils.ReceiverFile) error {
 	if err := rt.Conn.WriteInt32(-1); err != nil {
 		return err
 	}
+	if err := rt.Conn.Flush(); err != nil {
+		return err
+	}
 
 	rt.Logger.Debug("generateFiles finished")
 	return nil
@@ -56,26 +74,66 @@ func (rt *Transfer) skipFile(f *utils.ReceiverFile, st os.FileInfo) (bool, error
 // rsync/generator.c:recv_generator
 func (rt *Transfer) recvGenerator(idx int, f *utils.ReceiverFile) error {
 	if rt.listOnly() {
-		fmt.Fprintf(rt.Env.Stdout, "%s %11.0f %s %s\n",
-			f.FileMode().String(),
-			float64(f.Length), // TODO: rsync prints decimal separators
-			f.ModTime.Format("2006/01/02 15:04:05"),
-			f.Name)
-		return nil
+		e := listEntry(f)
+		_, err := fmt.Fprintln(rt.Env.Stdout, e.Format(rt.Opts.HumanReadable))
+		return err
 	}
 	rt.Logger.Debug("recv_generator", "file", f)
 
+	if rt.Opts.DaemonFilters.Excluded(f.Name, f.FileMode().IsDir()) {
+		rt.refuse(f)
+		rt.obs().OnSkip(observed(f), rsyncstats.SkipExcluded)
+		return rt.xferError(fmt.Sprintf("skipping daemon-excluded file \"%s\"\n", f.Name))
+	}
+
 	if !f.FileMode().IsRegular() {
 		// None of the Preserve* options is enabled, so just skip over
 		// non-regular files.
+		if !f.FileMode().IsDir() {
+			rt.obs().OnSkip(observed(f), rsyncstats.SkipNonRegular)
+		}
 		return nil
 	}
 
-	requestFullFile := func() error {
+	if rt.Opts.MaxSize >= 0 && f.Length > rt.Opts.MaxSize {
+		rt.obs().OnSkip(observed(f), rsyncstats.SkipMaxSize)
+		if rt.Opts.Verbose {
+			return rt.info(fmt.Sprintf("%s is over max-size\n", f.Name))
+		}
+		return nil
+	}
+	if rt.Opts.MinSize >= 0 && f.Length < rt.Opts.MinSize {
+		rt.obs().OnSkip(observed(f), rsyncstats.SkipMinSize)
+		if rt.Opts.Verbose {
+			return rt.info(fmt.Sprintf("%s is under min-size\n", f.Name))
+		}
+		return nil
+	}
+
+	if rt.Opts.PreserveHardlinks {
+		if linked, err := rt.hardLinkCheck(idx, f); linked || err != nil {
+			return err
+		}
+	}
+
+	// rsync counts new files when itemizing them (ITEM_IS_NEW), so only once
+	// they are requested.
+	var isNew bool
+	requestFullFile := func(iflags int32) error {
+		if ok, err := rt.consult(OpReceive, f); !ok || err != nil {
+			return err
+		}
+		if err := rt.reserve(f); err != nil {
+			return err
+		}
 		rt.Logger.Debug("requesting", "file", f)
+		rt.setItemFlags(f, iflags)
 		if err := rt.Conn.WriteInt32(int32(idx)); err != nil {
 			return err
 		}
+		if isNew {
+			rt.stats.CreatedFiles.Add(f.FileMode())
+		}
 		if rt.Opts.DryRun {
 			return nil
 		}
@@ -86,37 +144,73 @@ func (rt *Transfer) recvGenerator(idx int, f *utils.ReceiverFile) error {
 		return nil
 	}
 
-	st, in, err := rt.Files.Read(&utils.SenderFile{WPath: f.Name})
+	fnamecmp := f.Name
+	st, in, err := rt.readFile(&utils.SenderFile{WPath: fnamecmp})
+	isNew = err != nil
+	if err != nil && len(rt.Opts.BasisDirs) > 0 {
+		basis, done, err := rt.tryDestsReg(f)
+		if done || err != nil {
+			return err
+		}
+		if basis != "" {
+			fnamecmp = basis
+		}
+	}
+	if err != nil && fnamecmp == f.Name && rt.Opts.FuzzyBasis > 0 {
+		fuzzy, err := rt.findFuzzy(f)
+		if err != nil {
+			return err
+		}
+		if fuzzy != "" {
+			rt.Logger.Debug("fuzzy basis selected", "file", f, "basis", fuzzy)
+			fnamecmp = fuzzy
+		}
+	}
+	if fnamecmp != f.Name {
+		st, in, err = rt.readFile(&utils.SenderFile{WPath: fnamecmp})
+	}
 	if err != nil {
 		rt.Logger.Error("failed to open file", "st", st, "file", f, "err", err)
-		return requestFullFile()
+		return requestFullFile(rsync.ITEM_TRANSFER | rsync.ITEM_IS_NEW)
 	}
 
 	defer in.Close()
 
-	skip, err := rt.skipFile(f, st)
-	if err != nil {
-		return err
-	}
-
-	if skip {
-		rt.Logger.Debug("skipping", "file", f)
-		return nil
-	}
-
-	if rt.Opts.DryRun {
-		if err := rt.Conn.WriteInt32(int32(idx)); err != nil {
+	iflags := int32(rsync.ITEM_TRANSFER | rsync.ITEM_IS_NEW)
+	if fnamecmp == f.Name {
+		skip, err := rt.skipFile(f, st)
+		if err != nil {
 			return err
 		}
 
-		return nil
+		if skip {
+			rt.Logger.Debug("skipping", "file", f)
+			rt.obs().OnSkip(observed(f), rsyncstats.SkipUpToDate)
+			return rt.reportItem(f, rt.itemize(f, st, 0), "")
+		}
+		iflags = rt.itemize(f, st, rsync.ITEM_TRANSFER)
+	} else {
+		rt.setBasisFile(f, fnamecmp)
 	}
 
-	rt.Logger.Debug("sending sums", "file", f, "st", st)
+	if ok, err := rt.consult(OpReceive, f); !ok || err != nil {
+		return err
+	}
+	if err := rt.reserve(f); err != nil {
+		return err
+	}
+	rt.setItemFlags(f, iflags)
 	if err := rt.Conn.WriteInt32(int32(idx)); err != nil {
 		return err
 	}
+	if isNew {
+		rt.stats.CreatedFiles.Add(f.FileMode())
+	}
+	if rt.Opts.DryRun {
+		return nil
+	}
 
+	rt.Logger.Debug("sending sums", "file", f, "st", st)
 	err = rt.generateAndSendSums(in, st.Size())
 	if err != nil {
 		rt.Logger.Error("failed to send sums", "file", f, "err", err)
@@ -0,0 +1,83 @@
+package rsyncreceiver_test
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/picosh/go-rsync-receiver/localfs"
+	"github.com/picosh/go-rsync-receiver/rsyncreceiver"
+	"github.com/picosh/go-rsync-receiver/rsyncsender"
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
+	"github.com/picosh/go-rsync-receiver/rsyncwire"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+// frameCounter counts the writes of a BufferedMultiplexWriter, i.e. the
+// frames it flushes.
+type frameCounter struct{ frames int }
+
+func (c *frameCounter) Write(p []byte) (int, error) {
+	c.frames++
+	return len(p), nil
+}
+
+func TestGenerateFilesFlushes(t *testing.T) {
+	var wire frameCounter
+	rt := &rsyncreceiver.Transfer{
+		Opts:   &rsyncreceiver.TransferOpts{MaxSize: -1, MinSize: -1},
+		Dest:   "/",
+		Conn:   &rsyncwire.Conn{Writer: &rsyncwire.BufferedMultiplexWriter{Writer: &wire}},
+		Files:  localfs.New(t.TempDir()),
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	fileList := []*utils.ReceiverFile{
+		{Name: "a", Mode: utils.ModeFromFileMode(0o644), Length: 1},
+		{Name: "b", Mode: utils.ModeFromFileMode(0o644), Length: 1},
+	}
+	if err := rt.GenerateFiles(fileList); err != nil {
+		t.Fatal(err)
+	}
+	// One frame per requested file, followed by one per phase: the sender
+	// must not wait for the end of the phase to start sending a file.
+	if got, want := wire.frames, len(fileList)+2; got != want {
+		t.Errorf("GenerateFiles wrote %d frames, want %d", got, want)
+	}
+}
+
+func TestCreatedFiles(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"new.txt":     "new",
+		"skipped.txt": "skipped",
+		"changed.txt": "new content",
+	})
+	writeFiles(t, dst, map[string]string{"changed.txt": "old"})
+
+	// Files which are not requested are not created.
+	policy := func(op rsyncreceiver.Op, f *utils.ReceiverFile) rsyncreceiver.Decision {
+		if f.Name == "skipped.txt" {
+			return rsyncreceiver.Decision{Action: rsyncreceiver.Skip}
+		}
+		return rsyncreceiver.Decision{Action: rsyncreceiver.Allow}
+	}
+	configure := func(_ *rsyncsender.Transfer, rt *rsyncreceiver.Transfer) { rt.Policy = policy }
+	// The dry run does not create new.txt, the first real run does.
+	for _, tt := range []struct {
+		args string
+		want rsyncstats.FileCounts
+	}{
+		{"-r -n", rsyncstats.FileCounts{Reg: 1}},
+		{"-r", rsyncstats.FileCounts{Reg: 1}},
+		{"-r", rsyncstats.FileCounts{}},
+	} {
+		stats, err := receive(t, tt.args, src, dst, configure)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.CreatedFiles != tt.want {
+			t.Errorf("%s: CreatedFiles = %+v, want %+v", tt.args, stats.CreatedFiles, tt.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package rsyncreceiver
+
+import (
+	"os"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+type idev struct {
+	dev   int64
+	inode int64
+}
+
+type hardLink struct {
+	master *utils.ReceiverFile
+	f      *utils.ReceiverFile
+	iflags int32
+}
+
+// rsync/hlink.c:init_hard_links
+//
+// initHardLinks groups the regular files of the file list by device and
+// inode. The first file of each group is transferred, all others are
+// hard-linked to it once the transfer is done.
+func (rt *Transfer) initHardLinks(fileList []*utils.ReceiverFile) {
+	first := make(map[idev]*utils.ReceiverFile)
+	rt.hlinkMasters = make(map[int]*utils.ReceiverFile)
+	for idx, f := range fileList {
+		if !f.FileMode().IsRegular() {
+			continue
+		}
+		key := idev{dev: f.Dev, inode: f.Inode}
+		if master, ok := first[key]; ok {
+			rt.hlinkMasters[idx] = master
+			continue
+		}
+		first[key] = f
+	}
+}
+
+// rsync/hlink.c:hard_link_check
+//
+// hardLinkCheck returns true if f will be hard-linked to another file of its
+// link group instead of being transferred. Like received files, links are
+// subject to the Policy and Limits; a link to a file which was not received
+// is skipped.
+func (rt *Transfer) hardLinkCheck(idx int, f *utils.ReceiverFile) (bool, error) {
+	master, ok := rt.hlinkMasters[idx]
+	if !ok {
+		return false, nil
+	}
+	if rt.refused[master] {
+		rt.Logger.Debug("hard link to refused file, skipping", "file", f, "master", master.Name)
+		rt.refuse(f)
+		rt.obs().OnSkip(observed(f), rsyncstats.SkipPolicy)
+		return true, nil
+	}
+	var existing os.FileInfo
+	if st, in, err := rt.readFile(&utils.SenderFile{WPath: f.Name}); err == nil {
+		in.Close()
+		if skip, _ := rt.skipFile(f, st); skip {
+			rt.Logger.Debug("hard link up to date", "file", f, "master", master.Name)
+			rt.obs().OnSkip(observed(f), rsyncstats.SkipHardLink)
+			return true, nil
+		}
+		existing = st
+	}
+	if ok, err := rt.consult(OpReceive, f); !ok || err != nil {
+		return true, err
+	}
+	if err := rt.reserve(f); err != nil {
+		return true, err
+	}
+	rt.obs().OnSkip(observed(f), rsyncstats.SkipHardLink)
+	rt.pendingLinks = append(rt.pendingLinks, hardLink{
+		master: master,
+		f:      f,
+		iflags: rt.itemize(f, existing, rsync.ITEM_LOCAL_CHANGE|rsync.ITEM_XNAME_FOLLOWS),
+	})
+	return true, nil
+}
+
+// rsync/hlink.c:do_hard_links
+func (rt *Transfer) doHardLinks() error {
+	for _, l := range rt.pendingLinks {
+		if err := rt.reportItem(l.f, l.iflags, l.master.Name); err != nil {
+			return err
+		}
+		if rt.Opts.DryRun {
+			continue
+		}
+		if rt.Opts.MakeBackups {
+			if err := rt.makeBackup(l.f.Name); err != nil {
+				return err
+			}
+		}
+		if err := rt.linkFile(l.master.Name, l.f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkFile creates f as a hard link to oldname, or as a copy of oldname if
+// Files does not support hard links.
+func (rt *Transfer) linkFile(oldname string, f *utils.ReceiverFile) error {
+	if !rt.Capabilities.CanWrite() {
+		return &utils.PermissionError{Op: "write", Name: f.Name}
+	}
+	if linker, ok := rt.Files.(utils.Linker); ok {
+		err := linker.Link(oldname, f.Name)
+		if err == nil {
+			rt.Logger.Debug("hard-linked", "file", f, "to", oldname)
+			return nil
+		}
+		rt.Logger.Debug("hard-linking failed, copying instead", "file", f, "to", oldname, "err", err)
+	}
+	return rt.copyFile(oldname, f)
+}
@@ -0,0 +1,108 @@
+package rsyncreceiver_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHardLinks(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{
+		"a.txt":     "first group",
+		"solo.txt":  "solo",
+		"x.txt":     "second group",
+		"sub/b.txt": "first group",
+		"sub/c.txt": "first group",
+		"sub/y.txt": "second group",
+	}
+	writeFiles(t, src, map[string]string{
+		"a.txt":    files["a.txt"],
+		"solo.txt": files["solo.txt"],
+		"x.txt":    files["x.txt"],
+	})
+	for link, target := range map[string]string{
+		"sub/b.txt": "a.txt",
+		"sub/c.txt": "a.txt",
+		"sub/y.txt": "x.txt",
+	} {
+		if err := os.MkdirAll(filepath.Join(src, filepath.Dir(link)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Link(filepath.Join(src, target), filepath.Join(src, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	groups := [][]string{
+		{"a.txt", "sub/b.txt", "sub/c.txt"},
+		{"x.txt", "sub/y.txt"},
+		{"solo.txt"},
+	}
+
+	stat := func(t *testing.T, dst, name string) os.FileInfo {
+		t.Helper()
+		fi, err := os.Stat(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi
+	}
+	// checkGroups verifies that the files of each group are hard links to
+	// each other, but not to files of other groups.
+	checkGroups := func(t *testing.T, dst string) {
+		t.Helper()
+		for i, group := range groups {
+			first := stat(t, dst, group[0])
+			for _, name := range group[1:] {
+				if !os.SameFile(first, stat(t, dst, name)) {
+					t.Errorf("%s is not linked to %s", name, group[0])
+				}
+			}
+			for _, other := range groups[i+1:] {
+				if os.SameFile(first, stat(t, dst, other[0])) {
+					t.Errorf("%s is linked to %s", group[0], other[0])
+				}
+			}
+		}
+	}
+
+	t.Run("New", func(t *testing.T) {
+		dst := t.TempDir()
+		if _, err := receive(t, "-rH", src, dst); err != nil {
+			t.Fatal(err)
+		}
+		checkFiles(t, dst, files)
+		checkGroups(t, dst)
+
+		// The links are retained when nothing changed.
+		if _, err := receive(t, "-rH", src, dst); err != nil {
+			t.Fatal(err)
+		}
+		checkGroups(t, dst)
+	})
+
+	t.Run("Existing", func(t *testing.T) {
+		// Files of the same name, which are not linked yet, are replaced.
+		dst := t.TempDir()
+		writeFiles(t, dst, map[string]string{
+			"sub/b.txt": "old",
+			"sub/y.txt": files["sub/y.txt"],
+		})
+		if _, err := receive(t, "-rH", src, dst); err != nil {
+			t.Fatal(err)
+		}
+		checkFiles(t, dst, files)
+		checkGroups(t, dst)
+	})
+
+	t.Run("NoHardLinks", func(t *testing.T) {
+		dst := t.TempDir()
+		if _, err := receive(t, "-r", src, dst); err != nil {
+			t.Fatal(err)
+		}
+		checkFiles(t, dst, files)
+		if os.SameFile(stat(t, dst, "a.txt"), stat(t, dst, "sub/b.txt")) {
+			t.Errorf("sub/b.txt is linked to a.txt without -H")
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package rsyncreceiver
+
+import (
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+// Limits are quotas which a server imposes on a session (see WithLimits).
+// Exceeding a limit aborts the session with an error before the offending
+// files are written. Zero values mean no limit.
+type Limits struct {
+	// MaxFiles is the maximum number of entries (including directories) in
+	// the file list.
+	MaxFiles int
+	// MaxFileListSize is the maximum size of the file list in bytes, as
+	// transmitted by the client.
+	MaxFileListSize int64
+	// MaxTotalSize is the maximum number of bytes of the files which are
+	// received, i.e. the total size of new and changed files.
+	MaxTotalSize int64
+}
+
+// checkFileList returns an error if a file list of files entries, of which
+// size bytes were received so far, exceeds l.
+func (l *Limits) checkFileList(files int, size int64) error {
+	if l.MaxFiles > 0 && files > l.MaxFiles {
+		return rsync.Errorf(rsync.RERR_MALLOC, "file list exceeds the server’s limit of %d files", l.MaxFiles)
+	}
+	if l.MaxFileListSize > 0 && size > l.MaxFileListSize {
+		return rsync.Errorf(rsync.RERR_MALLOC, "file list exceeds the server’s limit of %d bytes", l.MaxFileListSize)
+	}
+	return nil
+}
+
+// reserve accounts for receiving f, returning an error if that would exceed
+// Limits.MaxTotalSize.
+func (rt *Transfer) reserve(f *utils.ReceiverFile) error {
+	limit := rt.Opts.Limits.MaxTotalSize
+	if limit <= 0 || rt.Opts.DryRun {
+		return nil
+	}
+	if rt.requested+f.Length > limit {
+		return rsync.Errorf(rsync.RERR_FILEIO, "receiving %s would exceed the server’s limit of %d bytes", f.Name, limit)
+	}
+	rt.requested += f.Length
+	return nil
+}
@@ -0,0 +1,70 @@
+package rsyncreceiver
+
+import (
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/picosh/go-rsync-receiver/rsynclog"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+// ListEntry is a file of a received file list, as shown by rsync
+// --list-only.
+type ListEntry struct {
+	Name    string
+	Size    int64
+	Mode    fs.FileMode
+	ModTime time.Time
+	Uid     int32
+	Gid     int32
+	// LinkTarget is the target of a symlink (only sent with --links).
+	LinkTarget string
+}
+
+func listEntry(f *utils.ReceiverFile) ListEntry {
+	return ListEntry{
+		Name:       f.Name,
+		Size:       f.Length,
+		Mode:       f.FileMode(),
+		ModTime:    f.ModTime,
+		Uid:        f.Uid,
+		Gid:        f.Gid,
+		LinkTarget: f.LinkTarget,
+	}
+}
+
+// List returns the entries of a file list obtained with ReceiveFileList,
+// e.g. to browse a remote tree without transferring any file data.
+func List(fileList []*utils.ReceiverFile) []ListEntry {
+	entries := make([]ListEntry, 0, len(fileList))
+	for _, f := range fileList {
+		entries = append(entries, listEntry(f))
+	}
+	return entries
+}
+
+// rsync/generator.c:list_file_entry
+//
+// Format formats e like rsync --list-only, e.g.
+//
+//	-rw-r--r--      1,234,567 2024/01/02 03:04:05 dir/file.txt
+//
+// humanReadable is the rsync human_readable level (1 by default, see
+// rsyncopts.Options.HumanReadable).
+func (e *ListEntry) Format(humanReadable int) string {
+	sizeWidth := 14
+	if humanReadable == 0 {
+		sizeWidth = 11
+	}
+	var arrow string
+	if e.Mode&fs.ModeSymlink != 0 && e.LinkTarget != "" {
+		arrow = " -> " + e.LinkTarget
+	}
+	return fmt.Sprintf("%s %*s %s %s%s",
+		rsynclog.Permstring(e.Mode),
+		sizeWidth, rsynclog.HumanNum(e.Size, humanReadable),
+		e.ModTime.Format("2006/01/02 15:04:05"),
+		e.Name,
+		arrow)
+}
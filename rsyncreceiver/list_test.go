@@ -0,0 +1,34 @@
+package rsyncreceiver_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/rsyncreceiver"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+func TestListFormat(t *testing.T) {
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+	entries := rsyncreceiver.List([]*utils.ReceiverFile{
+		{Name: ".", Mode: rsync.S_IFDIR | 0o755, Length: 4096, ModTime: mtime},
+		{Name: "big.bin", Mode: rsync.S_IFREG | 0o644, Length: 1234567, ModTime: mtime},
+		{Name: "link", Mode: rsync.S_IFLNK | 0o777, Length: 7, ModTime: mtime, LinkTarget: "big.bin"},
+	})
+	for _, tt := range []struct {
+		idx           int
+		humanReadable int
+		want          string
+	}{
+		{0, 1, "drwxr-xr-x          4,096 2024/01/02 03:04:05 ."},
+		{1, 1, "-rw-r--r--      1,234,567 2024/01/02 03:04:05 big.bin"},
+		{1, 0, "-rw-r--r--     1234567 2024/01/02 03:04:05 big.bin"},
+		{1, 2, "-rw-r--r--          1.23M 2024/01/02 03:04:05 big.bin"},
+		{2, 1, "lrwxrwxrwx              7 2024/01/02 03:04:05 link -> big.bin"},
+	} {
+		if got := entries[tt.idx].Format(tt.humanReadable); got != tt.want {
+			t.Errorf("Format(%d) = %q, want %q", tt.humanReadable, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package rsyncreceiver
+
+import (
+	"io"
+	"os"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/rsynclog"
+	"github.com/picosh/go-rsync-receiver/rsyncwire"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+// info sends an informational message to the client, or prints it locally
+// when the connection is not multiplexed (like rsync’s rprintf(FINFO, …)).
+func (rt *Transfer) info(msg string) error {
+	if mw, ok := rt.Conn.Writer.(rsyncwire.MsgWriter); ok {
+		return rsyncwire.SendInfo(mw, msg)
+	}
+	_, err := io.WriteString(rt.Env.Stdout, msg)
+	return err
+}
+
+// xferError reports a per-file error to the client, or prints it locally
+// when the connection is not multiplexed (like rprintf(FERROR_XFER, …)).
+func (rt *Transfer) xferError(msg string) error {
+	if mw, ok := rt.Conn.Writer.(rsyncwire.MsgWriter); ok {
+		return rsyncwire.SendError(mw, msg)
+	}
+	_, err := io.WriteString(rt.Env.Stderr, msg)
+	return err
+}
+
+func (rt *Transfer) formatter(format string) *rsynclog.Formatter {
+	return &rsynclog.Formatter{
+		Format:        format,
+		PreserveTimes: rt.Opts.PreserveTimes,
+	}
+}
+
+// rsync/log.c:log_formatted
+//
+// logFormatted sends it to the client in outFormat and writes it to the
+// LogSink in logFormat. An empty format disables the respective output.
+func (rt *Transfer) logFormatted(it *rsynclog.Item, outFormat, logFormat string) error {
+	if rt.LogSink != nil && logFormat != "" && !rt.Opts.DryRun {
+		line := rt.formatter(logFormat).Render(it) + "\n"
+		rt.logMu.Lock()
+		_, err := io.WriteString(rt.LogSink, line)
+		rt.logMu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	if outFormat == "" {
+		return nil
+	}
+	return rt.info(rt.formatter(outFormat).Render(it) + "\n")
+}
+
+func (rt *Transfer) logFormat() string {
+	if rt.LogFormat == "" {
+		return rsynclog.DefaultFormat
+	}
+	return rt.LogFormat
+}
+
+// rsync/log.c:log_item
+//
+// logItem logs f with the itemize flags iflags. Like rsync with protocols
+// before 29, a server only writes transferred files to the LogSink: the
+// client’s sender logs them itself.
+func (rt *Transfer) logItem(f *utils.ReceiverFile, iflags int32, bytes int64, hlink string) error {
+	outFormat := rt.Opts.OutFormat
+	if _, ok := rt.Conn.Writer.(rsyncwire.MsgWriter); ok && iflags&rsync.ITEM_TRANSFER != 0 {
+		outFormat = ""
+	}
+	return rt.logFormatted(&rsynclog.Item{
+		Op:         "recv",
+		Name:       f.Name,
+		Mode:       f.FileMode(),
+		Size:       f.Length,
+		ModTime:    f.ModTime,
+		Uid:        f.Uid,
+		Gid:        f.Gid,
+		LinkTarget: f.LinkTarget,
+		HardLink:   hlink,
+		Flags:      iflags,
+		Bytes:      bytes,
+	}, outFormat, rt.logFormat())
+}
+
+// rsync/log.c:log_delete
+func (rt *Transfer) logDelete(f *utils.ReceiverFile) error {
+	outFormat := ""
+	if rt.Opts.Verbose || rt.Opts.OutFormat != "" {
+		if mw, ok := rt.Conn.Writer.(rsyncwire.MsgWriter); ok && rt.protocol() >= 29 {
+			// Let the client log the deletion in its own format.
+			if err := rsyncwire.SendDeleted(mw, f.Name, f.FileMode().IsDir()); err != nil {
+				return err
+			}
+		} else {
+			outFormat = deleteFormat(rt.Opts.OutFormat)
+		}
+	}
+	return rt.logFormatted(&rsynclog.Item{
+		Op:    "del.",
+		Name:  f.Name,
+		Mode:  f.FileMode(),
+		Size:  f.Length,
+		Flags: rsync.ITEM_DELETED,
+	}, outFormat, deleteFormat(rt.logFormat()))
+}
+
+// deleteFormat returns format if it can describe deletions, or rsync’s
+// default “deleting %n” otherwise.
+func deleteFormat(format string) string {
+	if rsynclog.Has(format, 'o') || rsynclog.Has(format, 'i') {
+		return format
+	}
+	return "deleting %n"
+}
+
+// rsync/generator.c:itemize
+//
+// itemize adds the flags describing how the existing file st differs from f
+// to iflags. st is nil if f does not exist yet.
+func (rt *Transfer) itemize(f *utils.ReceiverFile, st os.FileInfo, iflags int32) int32 {
+	if st == nil {
+		return iflags | rsync.ITEM_IS_NEW
+	}
+	if f.FileMode().IsRegular() && st.Size() != f.Length {
+		iflags |= rsync.ITEM_REPORT_SIZE
+	}
+	if rt.Opts.PreserveTimes && !st.ModTime().Equal(f.ModTime) ||
+		!rt.Opts.PreserveTimes && iflags&rsync.ITEM_TRANSFER != 0 {
+		iflags |= rsync.ITEM_REPORT_TIME
+	}
+	if rt.Opts.PreservePerms && st.Mode().Perm() != f.FileMode().Perm() {
+		iflags |= rsync.ITEM_REPORT_PERMS
+	}
+	return iflags
+}
+
+// reportItem logs files which are not transferred, if anything changed or
+// if -ii was specified.
+func (rt *Transfer) reportItem(f *utils.ReceiverFile, iflags int32, hlink string) error {
+	if iflags&rsync.SIGNIFICANT_ITEM_FLAGS == 0 && rt.Opts.ItemizeChanges < 2 {
+		return nil
+	}
+	return rt.logItem(f, iflags, 0, hlink)
+}
+
+// setItemFlags remembers the itemize flags which the generator determined
+// for f, so that the receiver can log f once it was transferred.
+func (rt *Transfer) setItemFlags(f *utils.ReceiverFile, iflags int32) {
+	rt.itemMu.Lock()
+	defer rt.itemMu.Unlock()
+	if rt.itemFlags == nil {
+		rt.itemFlags = make(map[string]int32)
+	}
+	rt.itemFlags[f.Name] = iflags
+}
+
+// takeItemFlags returns the itemize flags for f (see setItemFlags).
+func (rt *Transfer) takeItemFlags(f *utils.ReceiverFile) int32 {
+	rt.itemMu.Lock()
+	defer rt.itemMu.Unlock()
+	iflags, ok := rt.itemFlags[f.Name]
+	if !ok {
+		return rsync.ITEM_TRANSFER
+	}
+	delete(rt.itemFlags, f.Name)
+	return iflags
+}
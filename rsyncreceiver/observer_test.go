@@ -0,0 +1,174 @@
+package rsyncreceiver_test
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/picosh/go-rsync-receiver/rsyncreceiver"
+	"github.com/picosh/go-rsync-receiver/rsyncsender"
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
+)
+
+// recorder records Observer events as strings like “start a.txt”.
+type recorder struct {
+	mu       sync.Mutex
+	events   []string
+	progress map[string][]int64
+}
+
+func (r *recorder) record(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) OnFileList(files []rsyncstats.File) { r.record("list %d", len(files)) }
+func (r *recorder) OnFileStart(f rsyncstats.File)      { r.record("start %s", f.Name) }
+func (r *recorder) OnDelete(f rsyncstats.File)         { r.record("delete %s", f.Name) }
+
+func (r *recorder) OnProgress(f rsyncstats.File, bytes int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.progress == nil {
+		r.progress = make(map[string][]int64)
+	}
+	if len(r.progress[f.Name]) == 0 {
+		r.events = append(r.events, "progress "+f.Name)
+	}
+	r.progress[f.Name] = append(r.progress[f.Name], bytes)
+}
+
+func (r *recorder) OnFileDone(f rsyncstats.File, result rsyncstats.FileResult) {
+	r.record("done %s %v", f.Name, result.Err)
+}
+
+func (r *recorder) OnSkip(f rsyncstats.File, reason rsyncstats.SkipReason) {
+	r.record("skip %s: %s", f.Name, reason)
+}
+
+func TestObserver(t *testing.T) {
+	big := strings.Repeat("0123456789abcdef", 16*1024)
+	src := t.TempDir()
+	transferred := map[string]string{
+		"a.txt":       "new",
+		"sub/big.bin": big,
+	}
+	writeFiles(t, src, transferred)
+	writeFiles(t, src, map[string]string{"same.txt": "same"})
+
+	for _, mode := range []string{"--delete-before", "--delete-after"} {
+		t.Run(mode, func(t *testing.T) {
+			dst := t.TempDir()
+			writeFiles(t, dst, map[string]string{
+				"same.txt":  "same",
+				"extra.txt": "extra",
+			})
+			var r recorder
+			observe := func(_ *rsyncsender.Transfer, rt *rsyncreceiver.Transfer) { rt.Observer = &r }
+			if _, err := receive(t, "-r "+mode, src, dst, observe); err != nil {
+				t.Fatal(err)
+			}
+			events := r.events
+
+			index := func(event string) int {
+				t.Helper()
+				i := slices.Index(events, event)
+				if i == -1 {
+					t.Fatalf("no %q event", event)
+				}
+				if slices.Index(events[i+1:], event) != -1 {
+					t.Errorf("more than one %q event", event)
+				}
+				return i
+			}
+
+			// “.”, a.txt, same.txt, sub and sub/big.bin
+			if got, want := events[0], "list 5"; got != want {
+				t.Errorf("first event = %q, want %q", got, want)
+			}
+			index("skip same.txt: " + string(rsyncstats.SkipUpToDate))
+			if slices.Contains(events, "start same.txt") {
+				t.Errorf("up to date same.txt was transferred")
+			}
+
+			var first, last int
+			for i, name := range []string{"a.txt", "sub/big.bin"} { // in file list order
+				start := index("start " + name)
+				progress := index("progress " + name)
+				done := index("done " + name + " <nil>")
+				if !(start < progress && progress < done) {
+					t.Errorf("%s: start, progress and done events out of order: %q", name, events)
+				}
+				if i == 0 {
+					first = start
+				} else if start < last {
+					t.Errorf("%s started before the previous file was done: %q", name, events)
+				}
+				last = done
+
+				p := r.progress[name]
+				if !slices.IsSorted(p) {
+					t.Errorf("%s: progress goes backwards: %v", name, p)
+				}
+				if got, want := p[len(p)-1], int64(len(transferred[name])); got != want {
+					t.Errorf("%s: final progress %d, want %d", name, got, want)
+				}
+			}
+
+			del := index("delete extra.txt")
+			switch mode {
+			case "--delete-before":
+				if del > first {
+					t.Errorf("deletion after the first transfer: %q", events)
+				}
+			case "--delete-after":
+				if del < last {
+					t.Errorf("deletion before the last transfer: %q", events)
+				}
+			}
+		})
+	}
+}
+
+// senderEvents records the Observer events of the sender, which calls the
+// Observer from a single goroutine.
+type senderEvents struct {
+	rsyncstats.NopObserver
+	events []string
+}
+
+func (s *senderEvents) OnFileList(files []rsyncstats.File) { s.events = append(s.events, "list") }
+func (s *senderEvents) OnFileStart(f rsyncstats.File)      { s.events = append(s.events, "start "+f.Name) }
+
+func (s *senderEvents) OnFileDone(f rsyncstats.File, result rsyncstats.FileResult) {
+	s.events = append(s.events, "done "+f.Name)
+}
+
+func TestSenderObserver(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"a.txt":       "a",
+		"same.txt":    "same",
+		"sub/big.bin": strings.Repeat("0123456789abcdef", 16*1024),
+	})
+	writeFiles(t, dst, map[string]string{"same.txt": "same"})
+
+	var s senderEvents
+	observe := func(st *rsyncsender.Transfer, _ *rsyncreceiver.Transfer) { st.Observer = &s }
+	if _, err := receive(t, "-r", src, dst, observe); err != nil {
+		t.Fatal(err)
+	}
+	// Up to date files are not sent, the others in file list order.
+	want := []string{
+		"list",
+		"start a.txt", "done a.txt",
+		"start sub/big.bin", "done sub/big.bin",
+	}
+	if !slices.Equal(s.events, want) {
+		t.Errorf("events = %q, want %q", s.events, want)
+	}
+}
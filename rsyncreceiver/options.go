@@ -0,0 +1,96 @@
+package rsyncreceiver
+
+import (
+	"io"
+
+	"github.com/picosh/go-rsync-receiver/rsyncsender"
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
+	"github.com/picosh/go-rsync-receiver/rsyncwire"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+// Option customizes a session started by ClientRun.
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	limiter  *rsyncwire.Limiter
+	stats    *rsyncstats.TransferStats
+	observer rsyncstats.Observer
+
+	logSink   io.Writer
+	logFormat string
+
+	filters      *rsyncsender.FilterRuleList
+	maxFileSize  int64
+	owner        *owner
+	capabilities *utils.Capabilities
+	limits       Limits
+	policy       Policy
+}
+
+type owner struct{ uid, gid int32 }
+
+// WithLimiter limits the bandwidth of the session in both directions with l,
+// overriding the client’s --bwlimit option. Sharing l between sessions
+// enforces a combined budget, e.g. per user.
+func WithLimiter(l *rsyncwire.Limiter) Option {
+	return func(o *clientOptions) { o.limiter = l }
+}
+
+// WithStats stores the statistics of the session in stats once ClientRun
+// returns (even if the transfer failed after the file list was exchanged).
+func WithStats(stats *rsyncstats.TransferStats) Option {
+	return func(o *clientOptions) { o.stats = stats }
+}
+
+// WithObserver notifies o about the progress of the session.
+func WithObserver(o rsyncstats.Observer) Option {
+	return func(co *clientOptions) { co.observer = o }
+}
+
+// WithLogSink writes a line in format (rsynclog.DefaultFormat if empty) to w
+// for each file of the session, like rsync’s --log-file.
+func WithLogSink(w io.Writer, format string) Option {
+	return func(co *clientOptions) {
+		co.logSink = w
+		co.logFormat = format
+	}
+}
+
+// WithFilters excludes the files matching l from the session, regardless of
+// the client’s filter rules, like the filter parameters of rsyncd.conf: they
+// are neither received nor deleted.
+func WithFilters(l *rsyncsender.FilterRuleList) Option {
+	return func(co *clientOptions) { co.filters = l }
+}
+
+// WithLimits aborts the session once it exceeds one of l’s limits, e.g. to
+// enforce per-user quotas.
+func WithLimits(l Limits) Option {
+	return func(co *clientOptions) { co.limits = l }
+}
+
+// WithPolicy consults p about each file before it is received or deleted.
+func WithPolicy(p Policy) Option {
+	return func(co *clientOptions) { co.policy = p }
+}
+
+// WithCapabilities restricts the operations of the session on its file
+// system to c. Sessions which need refused operations fail before accessing
+// the file system.
+func WithCapabilities(c *utils.Capabilities) Option {
+	return func(co *clientOptions) { co.capabilities = c }
+}
+
+// WithMaxFileSize skips files larger than size bytes, like --max-size (the
+// client’s --max-size applies if it is smaller).
+func WithMaxFileSize(size int64) Option {
+	return func(co *clientOptions) { co.maxFileSize = size }
+}
+
+// WithOwner attributes all received files to uid and gid instead of the ids
+// sent by the client. A negative id keeps the client’s. The owner is applied
+// if the file system implements utils.Chowner.
+func WithOwner(uid, gid int32) Option {
+	return func(co *clientOptions) { co.owner = &owner{uid: uid, gid: gid} }
+}
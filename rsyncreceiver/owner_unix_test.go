@@ -0,0 +1,34 @@
+//go:build unix
+
+package rsyncreceiver_test
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/picosh/go-rsync-receiver/rsyncreceiver"
+)
+
+func TestOwner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner of files requires root")
+	}
+	src := t.TempDir()
+	dst := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "owned.txt"), []byte("owned"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if stderr, err := transfer(t, "-r", nil, src, dst, true, rsyncreceiver.WithOwner(1234, 5678)); err != nil {
+		t.Fatalf("%v (stderr: %q)", err, stderr)
+	}
+	fi, err := os.Lstat(filepath.Join(dst, "owned.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	if st.Uid != 1234 || st.Gid != 5678 {
+		t.Errorf("owned.txt is owned by %d:%d, want 1234:5678", st.Uid, st.Gid)
+	}
+}
@@ -0,0 +1,94 @@
+package rsyncreceiver
+
+import (
+	"fmt"
+
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
+	"github.com/picosh/go-rsync-receiver/rsyncwire"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+// Op is the operation a Policy is consulted about.
+type Op int
+
+const (
+	// OpReceive is receiving a new or changed file from the client.
+	OpReceive Op = iota
+	// OpDelete is deleting an extraneous file (--delete).
+	OpDelete
+)
+
+// Action is what a Policy decides to do with a file.
+type Action int
+
+const (
+	// Allow proceeds with the operation.
+	Allow Action = iota
+	// Skip leaves the file alone and warns the client.
+	Skip
+	// Fail leaves the file alone and reports an error to the client, which
+	// makes the transfer incomplete (like a file which could not be read).
+	Fail
+)
+
+// Decision is a Policy’s verdict about a file. Message is reported to the
+// client: as information for Allow (e.g. to annotate the file), as warning
+// for Skip and as error for Fail.
+type Decision struct {
+	Action  Action
+	Message string
+}
+
+// Policy is consulted before each file is requested from the client and
+// before each extraneous file is deleted, e.g. to reject uploads by name or
+// size. It is called from one goroutine at a time.
+type Policy func(op Op, f *utils.ReceiverFile) Decision
+
+// warning sends a warning to the client, or prints it locally when the
+// connection is not multiplexed (like rprintf(FWARNING, …)).
+func (rt *Transfer) warning(msg string) error {
+	if mw, ok := rt.Conn.Writer.(rsyncwire.MsgWriter); ok {
+		return rsyncwire.SendWarning(mw, msg)
+	}
+	_, err := fmt.Fprint(rt.Env.Stderr, msg)
+	return err
+}
+
+// consult asks the Policy about op on f, reports its decision to the client
+// and returns whether to proceed.
+func (rt *Transfer) consult(op Op, f *utils.ReceiverFile) (bool, error) {
+	if rt.Policy == nil {
+		return true, nil
+	}
+	d := rt.Policy(op, f)
+	verb := "receive"
+	if op == OpDelete {
+		verb = "delete"
+	}
+	msg := d.Message
+	if msg == "" && d.Action != Allow {
+		msg = "denied by server policy"
+	}
+	if d.Action == Allow {
+		if msg == "" {
+			return true, nil
+		}
+		return true, rt.info(fmt.Sprintf("%s: %s\n", f.Name, msg))
+	}
+	if op == OpReceive {
+		rt.refuse(f)
+		rt.obs().OnSkip(observed(f), rsyncstats.SkipPolicy)
+	}
+	if d.Action == Skip {
+		return false, rt.warning(fmt.Sprintf("WARNING: not going to %s \"%s\": %s\n", verb, f.Name, msg))
+	}
+	return false, rt.xferError(fmt.Sprintf("ERROR: refusing to %s \"%s\": %s\n", verb, f.Name, msg))
+}
+
+// refuse records that f is not received.
+func (rt *Transfer) refuse(f *utils.ReceiverFile) {
+	if rt.refused == nil {
+		rt.refused = make(map[*utils.ReceiverFile]bool)
+	}
+	rt.refused[f] = true
+}
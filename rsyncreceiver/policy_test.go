@@ -0,0 +1,180 @@
+package rsyncreceiver_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/picosh/go-rsync-receiver/rsyncreceiver"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+func TestPolicy(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+	for dir, files := range map[string]map[string]string{
+		src: {
+			"photo.jpg":      "jpeg",
+			"virus.exe":      "MZ",
+			"secret/key.txt": "key",
+		},
+		dst: {
+			"old.log": "log",
+			"keep.db": "db",
+		},
+	} {
+		for name, content := range files {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var consulted []string
+	policy := func(op rsyncreceiver.Op, f *utils.ReceiverFile) rsyncreceiver.Decision {
+		consulted = append(consulted, f.Name)
+		switch {
+		case op == rsyncreceiver.OpReceive && strings.HasSuffix(f.Name, ".exe"):
+			return rsyncreceiver.Decision{Action: rsyncreceiver.Fail, Message: "executables are not allowed"}
+		case op == rsyncreceiver.OpReceive && strings.HasPrefix(f.Name, "secret/"):
+			return rsyncreceiver.Decision{Action: rsyncreceiver.Skip, Message: "forbidden path"}
+		case op == rsyncreceiver.OpDelete && f.Name == "keep.db":
+			return rsyncreceiver.Decision{Action: rsyncreceiver.Skip}
+		}
+		return rsyncreceiver.Decision{Action: rsyncreceiver.Allow}
+	}
+	stderr, err := transfer(t, "-r --delete", nil, src, dst, true, rsyncreceiver.WithPolicy(policy))
+	if err == nil {
+		t.Errorf("transfer with a failed file unexpectedly succeeded")
+	}
+	for _, want := range []string{
+		`ERROR: refusing to receive "virus.exe": executables are not allowed`,
+		`WARNING: not going to receive "secret/key.txt": forbidden path`,
+		`WARNING: not going to delete "keep.db": denied by server policy`,
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr does not contain %q: %q", want, stderr)
+		}
+	}
+
+	for name, want := range map[string]bool{
+		"photo.jpg":      true,
+		"virus.exe":      false,
+		"secret/key.txt": false,
+		"old.log":        false,
+		"keep.db":        true,
+	} {
+		_, err := os.Stat(filepath.Join(dst, name))
+		if got := err == nil; got != want {
+			t.Errorf("%s exists = %v, want %v", name, got, want)
+		}
+	}
+	for _, name := range []string{"photo.jpg", "old.log"} {
+		if !strings.Contains(strings.Join(consulted, " "), name) {
+			t.Errorf("policy was not consulted about %s (consulted: %q)", name, consulted)
+		}
+	}
+}
+
+func TestPolicyLinks(t *testing.T) {
+	refuse := func(name string, action rsyncreceiver.Action) rsyncreceiver.Option {
+		return rsyncreceiver.WithPolicy(func(op rsyncreceiver.Op, f *utils.ReceiverFile) rsyncreceiver.Decision {
+			if op == rsyncreceiver.OpReceive && f.Name == name {
+				return rsyncreceiver.Decision{Action: action}
+			}
+			return rsyncreceiver.Decision{Action: rsyncreceiver.Allow}
+		})
+	}
+	exists := func(t *testing.T, dst string, want map[string]bool) {
+		t.Helper()
+		for name, want := range want {
+			_, err := os.Stat(filepath.Join(dst, name))
+			if got := err == nil; got != want {
+				t.Errorf("%s exists = %v, want %v", name, got, want)
+			}
+		}
+	}
+
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "a.bin"), []byte("linked"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(src, "a.bin"), filepath.Join(src, "b.bin")); err != nil {
+		t.Fatal(err)
+	}
+	// The protocol transmits modification times in seconds.
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "a.bin"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	basis := func(t *testing.T, dst string, names ...string) {
+		t.Helper()
+		if err := os.Mkdir(filepath.Join(dst, "base"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range names {
+			path := filepath.Join(dst, "base", name)
+			if err := os.WriteFile(path, []byte("linked"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	t.Run("HardLinkMasterSkipped", func(t *testing.T) {
+		dst := t.TempDir()
+		stderr, err := transfer(t, "-rH", nil, src, dst, true, refuse("a.bin", rsyncreceiver.Skip))
+		if err != nil {
+			t.Fatalf("%v (stderr: %q)", err, stderr)
+		}
+		exists(t, dst, map[string]bool{"a.bin": false, "b.bin": false})
+	})
+
+	t.Run("HardLinkFailed", func(t *testing.T) {
+		dst := t.TempDir()
+		stderr, err := transfer(t, "-rH", nil, src, dst, true, refuse("b.bin", rsyncreceiver.Fail))
+		if err == nil || !strings.Contains(stderr, `refusing to receive "b.bin"`) {
+			t.Errorf("err = %v, stderr = %q", err, stderr)
+		}
+		exists(t, dst, map[string]bool{"a.bin": true, "b.bin": false})
+	})
+
+	t.Run("LinkDest", func(t *testing.T) {
+		dst := t.TempDir()
+		basis(t, dst, "a.bin", "b.bin")
+		stderr, err := transfer(t, "-rt --link-dest=base", nil, src, dst, true, refuse("a.bin", rsyncreceiver.Skip))
+		if err != nil {
+			t.Fatalf("%v (stderr: %q)", err, stderr)
+		}
+		if !strings.Contains(stderr, `not going to receive "a.bin"`) {
+			t.Errorf("stderr = %q", stderr)
+		}
+		exists(t, dst, map[string]bool{"a.bin": false, "b.bin": true})
+		linked, err := os.Stat(filepath.Join(dst, "b.bin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if orig, err := os.Stat(filepath.Join(dst, "base", "b.bin")); err != nil || !os.SameFile(linked, orig) {
+			t.Errorf("b.bin is not hard-linked to base/b.bin")
+		}
+	})
+
+	t.Run("LinkDestOverQuota", func(t *testing.T) {
+		dst := t.TempDir()
+		basis(t, dst, "a.bin", "b.bin")
+		_, err := transfer(t, "-rt --link-dest=base", nil, src, dst, true,
+			rsyncreceiver.WithLimits(rsyncreceiver.Limits{MaxTotalSize: 8}))
+		if err == nil {
+			t.Errorf("transfer over quota unexpectedly succeeded")
+		}
+		exists(t, dst, map[string]bool{"a.bin": true, "b.bin": false})
+	})
+}
@@ -0,0 +1,127 @@
+package rsyncreceiver_test
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/picosh/go-rsync-receiver/localfs"
+	"github.com/picosh/go-rsync-receiver/rsyncopts"
+	"github.com/picosh/go-rsync-receiver/rsyncreceiver"
+	"github.com/picosh/go-rsync-receiver/rsyncsender"
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
+	"github.com/picosh/go-rsync-receiver/rsyncwire"
+)
+
+var mtime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkFiles(t *testing.T, dir string, want map[string]string) {
+	t.Helper()
+	got := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		got[filepath.ToSlash(rel)] = string(b)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("%s: got %d bytes, want %d bytes", name, len(got[name]), len(content))
+		}
+	}
+	for name := range got {
+		if _, ok := want[name]; !ok {
+			t.Errorf("unexpected file %s", name)
+		}
+	}
+}
+
+// receive transfers the contents of the directory src into dst, from a
+// sender to a receiver which are both configured with the command-line
+// arguments args. The configure functions can modify both sides before the
+// transfer starts.
+func receive(t *testing.T, args string, src, dst string, configure ...func(*rsyncsender.Transfer, *rsyncreceiver.Transfer)) (*rsyncstats.TransferStats, error) {
+	t.Helper()
+	pc, err := rsyncopts.ParseArguments(strings.Fields(args), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := pc.Options
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	const seed = 666
+
+	senderConn, receiverConn := net.Pipe()
+	crd, cwr := rsyncwire.CounterPair(senderConn, senderConn)
+	st := &rsyncsender.Transfer{
+		Opts:   opts,
+		Conn:   &rsyncwire.Conn{Reader: crd, Writer: cwr},
+		Seed:   seed,
+		Files:  localfs.New(src),
+		Logger: logger,
+	}
+	rt := &rsyncreceiver.Transfer{
+		Opts: rsyncreceiver.NewTransferOpts(opts),
+		Dest: "/",
+		Env: rsyncreceiver.Osenv{
+			Stdout: io.Discard,
+			Stderr: io.Discard,
+		},
+		Conn:   &rsyncwire.Conn{Reader: receiverConn, Writer: receiverConn},
+		Seed:   seed,
+		Files:  localfs.New(dst),
+		Logger: logger,
+	}
+	for _, f := range configure {
+		f(st, rt)
+	}
+
+	senderErr := make(chan error, 1)
+	go func() {
+		defer senderConn.Close()
+		_, err := st.Do(crd, cwr, []string{"."}, nil)
+		senderErr <- err
+	}()
+	stats, err := func() (*rsyncstats.TransferStats, error) {
+		fileList, err := rt.ReceiveFileList()
+		if err != nil {
+			return nil, err
+		}
+		return rt.Do(rt.Conn, fileList, false)
+	}()
+	// Unblock the sender if the receiver failed.
+	receiverConn.Close()
+	if serr := <-senderErr; err == nil {
+		err = serr
+	}
+	return stats, err
+}
@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/mmcloughlin/md4"
 	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
 	"github.com/picosh/go-rsync-receiver/utils"
 )
 
@@ -39,10 +41,26 @@ func (rt *Transfer) RecvFiles(fileList []*utils.ReceiverFile) error {
 	return nil
 }
 
-func (rt *Transfer) recvFile1(f *utils.ReceiverFile) error {
+func (rt *Transfer) recvFile1(f *utils.ReceiverFile) (err error) {
+	rt.stats.TransferredFiles++
+	rt.stats.TransferredSize += f.Length
+
+	of := observed(f)
+	rt.obs().OnFileStart(of)
+	start := time.Now()
+	literal, matched := rt.stats.LiteralData, rt.stats.MatchedData
+	defer func() {
+		rt.obs().OnFileDone(of, rsyncstats.FileResult{
+			LiteralData: rt.stats.LiteralData - literal,
+			MatchedData: rt.stats.MatchedData - matched,
+			Elapsed:     time.Since(start),
+			Err:         err,
+		})
+	}()
+
+	iflags := rt.takeItemFlags(f)
 	if rt.Opts.DryRun {
-		fmt.Println(f.Name)
-		return nil
+		return rt.logItem(f, iflags, 0, "")
 	}
 
 	localFile, err := rt.openLocalFile(f)
@@ -50,18 +68,27 @@ func (rt *Transfer) recvFile1(f *utils.ReceiverFile) error {
 		rt.Logger.Error("opening local file failed, continuing", "err", err, "file", f)
 	} else {
 		defer localFile.Close()
+
+		if rt.Opts.MakeBackups {
+			// Files.Put overwrites the file, so the backup needs to be made
+			// before receiving any data.
+			if err := rt.makeBackup(f.Name); err != nil {
+				return err
+			}
+		}
 	}
 
 	err = rt.receiveData(f, localFile)
 	if err != nil {
 		rt.Logger.Error("receiving data failed, continuing", "err", err, "file", f)
+		return err
 	}
-	return err
+	return rt.logItem(f, iflags, rt.stats.LiteralData-literal, "")
 }
 
 func (rt *Transfer) openLocalFile(f *utils.ReceiverFile) (utils.ReaderAtCloser, error) {
-	_, r, err := rt.Files.Read(&utils.SenderFile{
-		WPath:   f.Name,
+	_, r, err := rt.readFile(&utils.SenderFile{
+		WPath:   rt.basisFile(f),
 		Regular: true,
 	})
 
@@ -83,7 +110,10 @@ func (rt *Transfer) receiveData(f *utils.ReceiverFile, localFile utils.ReaderAtC
 
 	f.Reader = r
 
-	var wg sync.WaitGroup
+	var (
+		wg     sync.WaitGroup
+		putErr error
+	)
 	wg.Add(1)
 
 	go func() {
@@ -94,15 +124,14 @@ func (rt *Transfer) receiveData(f *utils.ReceiverFile, localFile utils.ReaderAtC
 			}
 		}()
 
-		_, err := rt.Files.Put(f)
-		if err != nil {
-			return
-		}
+		_, putErr = rt.putFile(f)
 	}()
 
 	h := md4.New()
 	binary.Write(h, binary.LittleEndian, rt.Seed)
 
+	of := observed(f)
+	var received int64
 	for {
 		token, data, err := rt.recvToken()
 		if err != nil {
@@ -112,6 +141,9 @@ func (rt *Transfer) receiveData(f *utils.ReceiverFile, localFile utils.ReaderAtC
 			break
 		}
 		if token > 0 {
+			rt.stats.LiteralData += int64(len(data))
+			received += int64(len(data))
+			rt.obs().OnProgress(of, received)
 			if _, err := h.Write(data); err != nil {
 				return err
 			}
@@ -137,6 +169,9 @@ func (rt *Transfer) receiveData(f *utils.ReceiverFile, localFile utils.ReaderAtC
 		if _, err := localFile.ReadAt(data, offset2); err != nil {
 			return err
 		}
+		rt.stats.MatchedData += int64(dataLen)
+		received += int64(dataLen)
+		rt.obs().OnProgress(of, received)
 
 		if _, err := h.Write(data); err != nil {
 			return err
@@ -162,9 +197,28 @@ func (rt *Transfer) receiveData(f *utils.ReceiverFile, localFile utils.ReaderAtC
 		return err
 	}
 	if !bytes.Equal(localSum, remoteSum) {
-		return fmt.Errorf("file corruption in %s", f.Name)
+		return rsync.Errorf(rsync.RERR_PARTIAL, "file corruption in %s", f.Name)
 	}
 	rt.Logger.Debug("checksum matches!", "localSum", localSum)
 
+	if putErr != nil {
+		return fmt.Errorf("write failed on %s: %w", f.Name, putErr)
+	}
+	return rt.setOwner(f)
+}
+
+// rsync/rsync.c:set_file_attrs
+//
+// setOwner applies the owner which the server forces (see WithOwner) to the
+// received file f. Like in rsync, failing to do so is not fatal.
+func (rt *Transfer) setOwner(f *utils.ReceiverFile) error {
+	chowner, ok := rt.Files.(utils.Chowner)
+	if rt.owner == nil || !ok || rt.Opts.DryRun {
+		return nil
+	}
+	if err := chowner.Lchown(f.Name, int(rt.owner.uid), int(rt.owner.gid)); err != nil {
+		rt.Logger.Error("chown failed", "file", f, "err", err)
+		return rt.xferError(fmt.Sprintf("chown \"%s\" failed: %v\n", f.Name, err))
+	}
 	return nil
 }
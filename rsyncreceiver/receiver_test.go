@@ -0,0 +1,43 @@
+package rsyncreceiver_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/picosh/go-rsync-receiver/localfs"
+	"github.com/picosh/go-rsync-receiver/rsyncreceiver"
+	"github.com/picosh/go-rsync-receiver/rsyncsender"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+var errNoSpace = errors.New("no space left on device")
+
+// fullFS fails to store regular files, like a full disk.
+type fullFS struct{ *localfs.FS }
+
+func (fsys fullFS) Put(f *utils.ReceiverFile) (int64, error) {
+	if f.FileMode().IsRegular() {
+		return 0, errNoSpace
+	}
+	return fsys.FS.Put(f)
+}
+
+func TestPutError(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+	writeFiles(t, src, map[string]string{"a.txt": "content"})
+
+	var r recorder
+	configure := func(_ *rsyncsender.Transfer, rt *rsyncreceiver.Transfer) {
+		rt.Files = fullFS{localfs.New(dst)}
+		rt.Observer = &r
+	}
+	if _, err := receive(t, "-r", src, dst, configure); !errors.Is(err, errNoSpace) {
+		t.Fatalf("receive = %v, want %v", err, errNoSpace)
+	}
+	checkFiles(t, dst, map[string]string{})
+	if want := "done a.txt write failed on a.txt: " + errNoSpace.Error(); !slices.Contains(r.events, want) {
+		t.Errorf("no %q event in %q", want, r.events)
+	}
+}
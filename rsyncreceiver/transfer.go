@@ -3,7 +3,11 @@ package rsyncreceiver
 import (
 	"io"
 	"log/slog"
+	"sync"
 
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/rsyncsender"
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
 	"github.com/picosh/go-rsync-receiver/rsyncwire"
 	"github.com/picosh/go-rsync-receiver/utils"
 )
@@ -20,6 +24,11 @@ type TransferOpts struct {
 	DryRun  bool
 
 	DeleteMode        bool
+	DeleteBefore      bool
+	DeleteDuring      bool
+	DeleteDelay       bool
+	DeleteAfter       bool
+	DeleteExcluded    bool
 	PreserveGid       bool
 	PreserveUid       bool
 	PreserveLinks     bool
@@ -31,6 +40,50 @@ type TransferOpts struct {
 	IgnoreTimes       bool
 	SizeOnly          bool
 	AlwaysChecksum    bool
+
+	MakeBackups  bool
+	BackupDir    string
+	BackupSuffix string
+
+	// BasisDirs are alternate basis directories, whose usage is determined by
+	// AltDestType (one of rsyncopts.COMPARE_DEST, COPY_DEST or LINK_DEST).
+	// They are relative to the destination, and transfers with directories
+	// outside of it (e.g. “../prev”) fail with RERR_SYNTAX.
+	BasisDirs   []string
+	AltDestType int
+
+	// FuzzyBasis is the number of times --fuzzy was specified.
+	FuzzyBasis int
+
+	// MaxDelete is the --max-delete limit. Zero disables deletions, a
+	// negative value means unlimited.
+	MaxDelete int
+
+	// Filters are the filter rules received from the client, which protect
+	// files from deletion.
+	Filters *rsyncsender.FilterRuleList
+
+	// DaemonFilters are the server’s filter rules (see WithFilters), which
+	// exclude files from being received or deleted.
+	DaemonFilters *rsyncsender.FilterRuleList
+
+	// MaxSize and MinSize are the sizes above and below which files are
+	// skipped (--max-size and --min-size), or negative for no limit.
+	MaxSize int64
+	MinSize int64
+
+	// Limits are the server’s quotas (see WithLimits).
+	Limits Limits
+
+	// ItemizeChanges is the number of times -i was specified.
+	ItemizeChanges int
+	// OutFormat is the --out-format in which changes are reported to the
+	// client (empty disables reporting).
+	OutFormat string
+
+	// HumanReadable selects the number format of --list-only output (see
+	// rsyncopts.Options.HumanReadable).
+	HumanReadable int
 }
 
 type Transfer struct {
@@ -45,9 +98,92 @@ type Transfer struct {
 	Seed     int32
 	IOErrors int32
 
+	// RemoteProtocol is the protocol version announced by the client (zero
+	// if unknown). Messages introduced after ProtocolVersion are only sent
+	// to clients which understand them.
+	RemoteProtocol int32
+
+	// stats are updated by the generator (created and deleted files) and
+	// the receiver (transferred files and data). Both goroutines update
+	// disjoint fields.
+	stats rsyncstats.TransferStats
+
+	basisMu    sync.Mutex
+	basisFiles map[string]string
+	// basisDirLists caches the directories of the alternate basis
+	// directories which -yy searched for fuzzy basis files.
+	basisDirLists map[string][]*utils.ReceiverFile
+
+	// destFiles caches the destination contents before the transfer.
+	destFiles      []*utils.ReceiverFile
+	deleted        map[string]bool
+	deletions      int
+	skippedDeletes int
+	// removePending is set when deleted files still need to be removed from
+	// Files, which does not implement utils.Deleter.
+	removePending bool
+	warnedIOError bool
+
+	// hlinkMasters maps file list indices of hard-linked files to the file
+	// which is transferred in their stead.
+	hlinkMasters map[int]*utils.ReceiverFile
+	pendingLinks []hardLink
+	// refused records the files which were not received because of the
+	// Policy or daemon filters, so that their hard links are skipped, too.
+	refused map[*utils.ReceiverFile]bool
+
+	// requested is the size of the files requested so far, for
+	// Limits.MaxTotalSize.
+	requested int64
+
 	Files utils.FS
+	// Capabilities, if non-nil, restrict the operations on Files.
+	Capabilities *utils.Capabilities
+	// Policy, if non-nil, decides about each file before it is received or
+	// deleted.
+	Policy Policy
+	// owner, if non-nil, is applied to all received files (see WithOwner).
+	owner *owner
+
+	// Observer, if non-nil, is notified about the progress of the transfer.
+	Observer rsyncstats.Observer
+
+	// LogSink, if non-nil, receives a line in LogFormat (default
+	// rsynclog.DefaultFormat) for each file which is transferred, changed
+	// or deleted.
+	LogSink   io.Writer
+	LogFormat string
+	logMu     sync.Mutex
+
+	itemMu    sync.Mutex
+	itemFlags map[string]int32
 
 	Logger *slog.Logger
 }
 
 func (rt *Transfer) listOnly() bool { return rt.Dest == "" }
+
+// protocol returns the protocol version used for the transfer.
+func (rt *Transfer) protocol() int32 {
+	if rt.RemoteProtocol == 0 {
+		return rsync.ProtocolVersion
+	}
+	return min(rt.RemoteProtocol, rsync.ProtocolVersion)
+}
+
+func (rt *Transfer) obs() rsyncstats.Observer {
+	if rt.Observer == nil {
+		return rsyncstats.NopObserver{}
+	}
+	return rt.Observer
+}
+
+// observed describes f for Observer callbacks.
+func observed(f *utils.ReceiverFile) rsyncstats.File {
+	return rsyncstats.File{
+		Name:    f.Name,
+		Size:    f.Length,
+		Mode:    f.FileMode(),
+		ModTime: f.ModTime,
+	}
+}
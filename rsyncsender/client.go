@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"slices"
+	"time"
 
 	"github.com/picosh/go-rsync-receiver/rsync"
 	"github.com/picosh/go-rsync-receiver/rsyncopts"
@@ -11,11 +13,28 @@ import (
 	"github.com/picosh/go-rsync-receiver/utils"
 )
 
-func ClientRun(logger *slog.Logger, opts *rsyncopts.Options, conn io.ReadWriter, filesystem utils.FS, paths []string, negotiate bool) error {
+func ClientRun(logger *slog.Logger, opts *rsyncopts.Options, conn io.ReadWriter, filesystem utils.FS, paths []string, negotiate bool, options ...Option) error {
 	var err error
 
+	var co clientOptions
+	for _, opt := range options {
+		opt(&co)
+	}
+
+	// rsync/io.c:check_timeout
+	timeout := time.Duration(opts.IOTimeoutSeconds()) * time.Second
+	conn = rsyncwire.WithTimeout(conn, timeout)
+
 	crd, cwr := rsyncwire.CounterPair(conn, conn)
 
+	// rsync/io.c:sleep_for_bwlimit
+	if co.limiter != nil {
+		rsyncwire.LimitPair(co.limiter, crd, cwr)
+	} else if bwlimit := opts.BwLimit(); bwlimit > 0 {
+		// Like rsync, --bwlimit only limits the data we send.
+		cwr.W = rsyncwire.NewLimiter(int64(bwlimit) * 1024).Writer(cwr.W)
+	}
+
 	const sessionChecksumSeed = 666
 
 	c := &rsyncwire.Conn{
@@ -23,8 +42,9 @@ func ClientRun(logger *slog.Logger, opts *rsyncopts.Options, conn io.ReadWriter,
 		Writer: cwr,
 	}
 
+	var remoteProtocol int32
 	if negotiate {
-		remoteProtocol, err := c.ReadInt32()
+		remoteProtocol, err = c.ReadInt32()
 		if err != nil {
 			return err
 		}
@@ -40,12 +60,19 @@ func ClientRun(logger *slog.Logger, opts *rsyncopts.Options, conn io.ReadWriter,
 
 	// Switch to multiplexing protocol, but only for server-side transmissions.
 	// Transmissions received from the client are not multiplexed.
-	mpx := &rsyncwire.MultiplexWriter{Writer: c.Writer}
+	mpx := &rsyncwire.BufferedMultiplexWriter{Writer: c.Writer}
 	c.Writer = mpx
+	if timeout > 0 {
+		stop := mpx.Keepalive(timeout, min(remoteProtocol, rsync.ProtocolVersion) >= 30)
+		defer stop()
+	}
+	// The sender reads and writes from the same goroutine, so it can flush
+	// its output whenever it needs to wait for the client.
+	c.Reader = &rsyncwire.FlushingReader{R: c.Reader, W: mpx}
 
 	defer func() {
 		if err != nil {
-			mpx.WriteMsg(rsyncwire.MsgError, fmt.Appendf(nil, "gokr-rsync [sender]: %v\n", err))
+			rsyncwire.SendError(mpx, fmt.Sprintf("gokr-rsync [sender]: %v\n", err))
 		}
 	}()
 
@@ -55,6 +82,12 @@ func ClientRun(logger *slog.Logger, opts *rsyncopts.Options, conn io.ReadWriter,
 		Seed:  sessionChecksumSeed,
 		Files: filesystem,
 
+		Capabilities:   co.capabilities,
+		RemoteProtocol: remoteProtocol,
+		Observer:       co.observer,
+		LogSink:        co.logSink,
+		LogFormat:      co.logFormat,
+
 		Logger: logger,
 	}
 	// receive the exclusion list (openrsync’s is always empty)
@@ -63,8 +96,17 @@ func ClientRun(logger *slog.Logger, opts *rsyncopts.Options, conn io.ReadWriter,
 		return err
 	}
 	logger.Debug("exclusion list read", "filters", exclusionList.Filters)
+	if co.filters != nil {
+		// The server’s rules take precedence over the client’s.
+		exclusionList = &FilterRuleList{
+			Filters: slices.Concat(co.filters.Filters, exclusionList.Filters),
+		}
+	}
 
 	stats, err := st.Do(crd, cwr, paths, exclusionList)
+	if stats != nil && co.stats != nil {
+		*co.stats = *stats
+	}
 	if err != nil {
 		return err
 	}
@@ -1,17 +1,89 @@
 package rsyncsender
 
 import (
-	"fmt"
 	"sort"
+	"time"
 
+	"github.com/picosh/go-rsync-receiver/rsync"
 	"github.com/picosh/go-rsync-receiver/rsyncstats"
 	"github.com/picosh/go-rsync-receiver/rsyncwire"
+	"github.com/picosh/go-rsync-receiver/utils"
 )
 
+// rsync/main.c:do_server_sender
+func (st *Transfer) Do(crd *rsyncwire.CountingReader, cwr *rsyncwire.CountingWriter, paths []string, exclusionList *FilterRuleList) (*rsyncstats.TransferStats, error) {
+	start := time.Now()
+	fileList, err := st.send(paths, exclusionList)
+	if err != nil {
+		return nil, err
+	}
+
+	// send statistics:
+	// total bytes read (from network connection)
+	if err := st.Conn.WriteInt64(crd.BytesRead); err != nil {
+		return nil, err
+	}
+	// total bytes written (to network connection)
+	if err := st.Conn.WriteInt64(cwr.BytesWritten); err != nil {
+		return nil, err
+	}
+	// total size of files
+	if err := st.Conn.WriteInt64(fileList.TotalSize); err != nil {
+		return nil, err
+	}
+	// The file list build and transfer times follow from protocol 29 on,
+	// but rsync.ProtocolVersion is 27.
+
+	st.Logger.Debug("reading final int32")
+
+	finish, err := st.Conn.ReadInt32()
+	if err != nil {
+		return nil, err
+	}
+	if finish != -1 {
+		return nil, rsync.Errorf(rsync.RERR_PROTOCOL, "protocol error: expected final -1, got %d", finish)
+	}
+
+	return st.result(crd, cwr, fileList, start)
+}
+
 // rsync/main.c:client_run am_sender
-func (st *Transfer) Do(crd *rsyncwire.CountingReader, cwr *rsyncwire.CountingWriter, paths []string, exclusionList *filterRuleList) (*rsyncstats.TransferStats, error) {
+//
+// DoClient sends paths to a receiving server. Unlike the server, the
+// client does not send statistics, it only waits for the receiver’s final
+// goodbye.
+func (st *Transfer) DoClient(crd *rsyncwire.CountingReader, cwr *rsyncwire.CountingWriter, paths []string, exclusionList *FilterRuleList) (*rsyncstats.TransferStats, error) {
+	start := time.Now()
+	fileList, err := st.send(paths, exclusionList)
+	if err != nil {
+		return nil, err
+	}
+	if err := st.Conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	// rsync/main.c:read_final_goodbye
+	goodbye, err := st.Conn.ReadInt32()
+	if err != nil {
+		return nil, err
+	}
+	if goodbye != -1 {
+		return nil, rsync.Errorf(rsync.RERR_PROTOCOL, "protocol error: expected final goodbye, got %d", goodbye)
+	}
+
+	return st.result(crd, cwr, fileList, start)
+}
+
+// send transmits the file list and then the files which the receiver
+// requests.
+func (st *Transfer) send(paths []string, exclusionList *FilterRuleList) (*fileList, error) {
+	// Sending only reads from Files, so refusing reads up front guards all
+	// accesses.
+	if !st.Capabilities.CanRead() {
+		return nil, &utils.PermissionError{Op: "read"}
+	}
 	if exclusionList == nil {
-		exclusionList = &filterRuleList{}
+		exclusionList = &FilterRuleList{}
 	}
 
 	// “Update exchange” as per
@@ -35,34 +107,25 @@ func (st *Transfer) Do(crd *rsyncwire.CountingReader, cwr *rsyncwire.CountingWri
 	if err := st.SendFiles(fileList); err != nil {
 		return nil, err
 	}
+	return fileList, nil
+}
 
-	// send statistics:
-	// total bytes read (from network connection)
-	if err := st.Conn.WriteInt64(crd.BytesRead); err != nil {
-		return nil, err
-	}
-	// total bytes written (to network connection)
-	if err := st.Conn.WriteInt64(cwr.BytesWritten); err != nil {
-		return nil, err
-	}
-	// total size of files
-	if err := st.Conn.WriteInt64(fileList.TotalSize); err != nil {
-		return nil, err
-	}
-
-	st.Logger.Debug("reading final int32")
+// result returns the statistics of the transfer, and an error if any files
+// could not be sent.
+func (st *Transfer) result(crd *rsyncwire.CountingReader, cwr *rsyncwire.CountingWriter, fileList *fileList, start time.Time) (*rsyncstats.TransferStats, error) {
+	stats := st.stats
+	stats.Read = crd.BytesRead
+	stats.Written = cwr.BytesWritten
+	stats.Size = fileList.TotalSize
+	stats.Elapsed = time.Since(start)
+	stats.DryRun = st.Opts.DryRun()
 
-	finish, err := st.Conn.ReadInt32()
-	if err != nil {
-		return nil, err
-	}
-	if finish != -1 {
-		return nil, fmt.Errorf("protocol error: expected final -1, got %d", finish)
+	// rsync/main.c:client_run (exit code for files which were not sent)
+	switch {
+	case st.ioError&rsync.IOERR_GENERAL != 0:
+		return &stats, rsync.ErrPartial
+	case st.ioError&rsync.IOERR_VANISHED != 0:
+		return &stats, rsync.ErrVanished
 	}
-
-	return &rsyncstats.TransferStats{
-		Read:    crd.BytesRead,
-		Written: cwr.BytesWritten,
-		Size:    fileList.TotalSize,
-	}, nil
+	return &stats, nil
 }
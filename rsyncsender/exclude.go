@@ -2,18 +2,25 @@ package rsyncsender
 
 import (
 	"io"
-	"path/filepath"
+	"iter"
+	"regexp"
 	"strings"
 
 	"github.com/picosh/go-rsync-receiver/rsyncwire"
 )
 
-type filterRuleList struct {
+// FilterRuleList is an ordered list of filter rules, of which the first
+// matching rule decides whether a file is excluded.
+type FilterRuleList struct {
 	Filters []*filterRule
 }
 
 // exclude.c:add_rule
-func (l *filterRuleList) addRule(fr *filterRule) {
+func (l *FilterRuleList) addRule(fr *filterRule) {
+	if fr.flag&filtruleClearList != 0 {
+		l.Filters = nil
+		return
+	}
 	if strings.HasSuffix(fr.pattern, "/") {
 		fr.flag |= filtruleDirectory
 		fr.pattern = strings.TrimSuffix(fr.pattern, "/")
@@ -23,22 +30,78 @@ func (l *filterRuleList) addRule(fr *filterRule) {
 	}) {
 		fr.flag |= filtruleWild
 	}
+	fr.re = compileRule(fr.pattern)
 	l.Filters = append(l.Filters, fr)
 }
 
+// AddRule parses a filter rule (e.g. “- *.tmp” or “P /backups/”) and
+// appends it to the list.
+func (l *FilterRuleList) AddRule(line string) error {
+	fr, err := parseFilter(line)
+	if err != nil {
+		return err
+	}
+	l.addRule(fr)
+	return nil
+}
+
 // exclude.c:check_filter
-func (l *filterRuleList) matches(name string) bool {
+//
+// check returns true if name is excluded by the rules which apply to the
+// given side (filtruleSenderSide or filtruleReceiverSide).
+func (l *FilterRuleList) check(name string, isDir bool, side int) bool {
+	if l == nil {
+		return false
+	}
 	for _, fr := range l.Filters {
+		if fr.flag&(filtruleSenderSide|filtruleReceiverSide) != 0 &&
+			fr.flag&side == 0 {
+			continue // rule does not apply to this side
+		}
+		if fr.flag&filtruleDirectory != 0 && !isDir {
+			continue
+		}
 		if fr.matches(name) {
-			return true
+			return fr.flag&filtruleInclude == 0
 		}
 	}
 	return false
 }
 
+// Excluded reports whether the sender excludes name from the transfer (by
+// exclude or hide rules), either directly or because one of its parent
+// directories is excluded.
+func (l *FilterRuleList) Excluded(name string, isDir bool) bool {
+	for dir := range parentDirs(name) {
+		if l.check(dir, true, filtruleSenderSide) {
+			return true
+		}
+	}
+	return l.check(name, isDir, filtruleSenderSide)
+}
+
+// Protected reports whether the receiver must not delete name (due to
+// exclude or protect rules).
+func (l *FilterRuleList) Protected(name string, isDir bool) bool {
+	return l.check(name, isDir, filtruleReceiverSide)
+}
+
+// parentDirs yields the parent directories of name, outermost first.
+func parentDirs(name string) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for i := 0; i < len(name); i++ {
+			if name[i] == '/' && i > 0 {
+				if !yield(name[:i]) {
+					return
+				}
+			}
+		}
+	}
+}
+
 // exclude.c:recv_filter_list
-func RecvFilterList(c *rsyncwire.Conn) (*filterRuleList, error) {
-	var l filterRuleList
+func RecvFilterList(c *rsyncwire.Conn) (*FilterRuleList, error) {
+	var l FilterRuleList
 	const exclusionListEnd = 0
 	for {
 		length, err := c.ReadInt32()
@@ -61,44 +124,68 @@ func RecvFilterList(c *rsyncwire.Conn) (*filterRuleList, error) {
 	return &l, nil
 }
 
+// exclude.c:send_filter_list
+//
+// SendFilterList transmits l to the peer, which reads it with RecvFilterList.
+func SendFilterList(c *rsyncwire.Conn, l *FilterRuleList) error {
+	if l != nil {
+		for _, fr := range l.Filters {
+			if err := c.WriteInt32(int32(len(fr.line))); err != nil {
+				return err
+			}
+			if err := c.WriteString(fr.line); err != nil {
+				return err
+			}
+		}
+	}
+	const exclusionListEnd = 0
+	return c.WriteInt32(exclusionListEnd)
+}
+
 const (
 	filtruleInclude = 1 << iota
 	filtruleClearList
 	filtruleDirectory
 	filtruleWild
+	filtruleSenderSide
+	filtruleReceiverSide
 )
 
 type filterRule struct {
+	line    string // as received, for SendFilterList
 	flag    int
 	pattern string
-}
-
-// exclude.c:rule_matches
-func (fr *filterRule) matches(name string) bool {
-	if fr.flag&filtruleWild != 0 {
-		panic("wildcard filter rules not yet implemented")
-	}
-	if !strings.ContainsRune(fr.pattern, '/') &&
-		fr.flag&filtruleWild == 0 {
-		name = filepath.Base(name)
-	}
-	return fr.pattern == name
+	re      *regexp.Regexp
 }
 
 // exclude.c:parse_filter_str / exclude.c:parse_rule_tok
 func parseFilter(line string) (*filterRule, error) {
-	rule := new(filterRule)
+	rule := &filterRule{line: line}
 
-	// We only support what rsync calls XFLG_OLD_PREFIXES
-	if strings.HasPrefix(line, "- ") {
+	// In addition to what rsync calls XFLG_OLD_PREFIXES, we support the
+	// short rule names of protect, risk, hide and show.
+	switch {
+	case strings.HasPrefix(line, "- "):
 		// clear include flag
 		rule.flag &= ^filtruleInclude
 		line = strings.TrimPrefix(line, "- ")
-	} else if strings.HasPrefix(line, "+ ") {
+	case strings.HasPrefix(line, "+ "):
 		// set include flag
 		rule.flag |= filtruleInclude
 		line = strings.TrimPrefix(line, "+ ")
-	} else if strings.HasPrefix(line, "!") {
+	case strings.HasPrefix(line, "P "):
+		rule.flag |= filtruleReceiverSide
+		line = strings.TrimPrefix(line, "P ")
+	case strings.HasPrefix(line, "R "):
+		rule.flag |= filtruleInclude | filtruleReceiverSide
+		line = strings.TrimPrefix(line, "R ")
+	case strings.HasPrefix(line, "H "):
+		rule.flag |= filtruleSenderSide
+		line = strings.TrimPrefix(line, "H ")
+	case strings.HasPrefix(line, "S "):
+		rule.flag |= filtruleInclude | filtruleSenderSide
+		line = strings.TrimPrefix(line, "S ")
+	case line == "!":
 		// set clear_list flag
 		rule.flag |= filtruleClearList
 	}
@@ -0,0 +1,85 @@
+package rsyncsender_test
+
+import (
+	"testing"
+
+	"github.com/picosh/go-rsync-receiver/rsyncsender"
+)
+
+func TestFilterRuleList(t *testing.T) {
+	for _, tt := range []struct {
+		rules     []string
+		name      string
+		isDir     bool
+		excluded  bool
+		protected bool
+	}{
+		// wildcards
+		{[]string{"- *.tmp"}, "a.tmp", false, true, true},
+		{[]string{"- *.tmp"}, "sub/a.tmp", false, true, true},
+		{[]string{"- *.tmp"}, "a.txt", false, false, false},
+		{[]string{"- *.tmp"}, "sub.tmp/a.txt", false, true, false},
+		{[]string{"- file?.txt"}, "file1.txt", false, true, true},
+		{[]string{"- file?.txt"}, "file10.txt", false, false, false},
+		{[]string{"- [ab].c"}, "a.c", false, true, true},
+		{[]string{"- [ab].c"}, "c.c", false, false, false},
+		{[]string{"- [!ab].c"}, "c.c", false, true, true},
+		{[]string{"- [!ab].c"}, "a.c", false, false, false},
+		{[]string{`- a\*b`}, "a*b", false, true, true},
+		{[]string{`- a\*b`}, "axb", false, false, false},
+		{[]string{"- a/**/z"}, "a/b/c/z", false, true, true},
+		{[]string{"- a/*/z"}, "a/b/c/z", false, false, false},
+		{[]string{"- dir/***"}, "dir", true, true, true},
+		{[]string{"- dir/***"}, "dir/a/b", false, true, true},
+		{[]string{"- dir/***"}, "dirt", false, false, false},
+
+		// anchoring and slashes
+		{[]string{"- /top"}, "top", false, true, true},
+		{[]string{"- /top"}, "sub/top", false, false, false},
+		{[]string{"- sub/x"}, "sub/x", false, true, true},
+		{[]string{"- sub/x"}, "a/sub/x", false, true, true},
+		{[]string{"- sub/x"}, "x", false, false, false},
+		{[]string{"- build/"}, "build", true, true, true},
+		{[]string{"- build/"}, "build", false, false, false},
+		{[]string{"- build/"}, "build/x", false, true, false},
+
+		// the first matching rule wins
+		{[]string{"+ keep.log", "- *.log"}, "keep.log", false, false, false},
+		{[]string{"+ keep.log", "- *.log"}, "other.log", false, true, true},
+		{[]string{"- *.log", "+ keep.log"}, "keep.log", false, true, true},
+
+		// receiver-side rules
+		{[]string{"P /backups/"}, "backups", true, false, true},
+		{[]string{"R *.bak", "P *"}, "x.bak", false, false, false},
+		{[]string{"R *.bak", "P *"}, "y", false, false, true},
+
+		// sender-side rules
+		{[]string{"H secret"}, "secret", false, true, false},
+		{[]string{"S public", "H *"}, "public", false, false, false},
+		{[]string{"S public", "H *"}, "x", false, true, false},
+
+		// “!” clears the list
+		{[]string{"- *.log", "!", "- *.tmp"}, "x.log", false, false, false},
+		{[]string{"- *.log", "!", "- *.tmp"}, "x.tmp", false, true, true},
+	} {
+		var l rsyncsender.FilterRuleList
+		for _, rule := range tt.rules {
+			if err := l.AddRule(rule); err != nil {
+				t.Fatalf("AddRule(%q): %v", rule, err)
+			}
+		}
+		if got := l.Excluded(tt.name, tt.isDir); got != tt.excluded {
+			t.Errorf("%q: Excluded(%q, %v) = %v, want %v", tt.rules, tt.name, tt.isDir, got, tt.excluded)
+		}
+		if got := l.Protected(tt.name, tt.isDir); got != tt.protected {
+			t.Errorf("%q: Protected(%q, %v) = %v, want %v", tt.rules, tt.name, tt.isDir, got, tt.protected)
+		}
+	}
+}
+
+func TestFilterRuleListNil(t *testing.T) {
+	var l *rsyncsender.FilterRuleList
+	if l.Excluded("a", false) || l.Protected("a", false) {
+		t.Errorf("a nil FilterRuleList excludes or protects files")
+	}
+}
@@ -3,20 +3,19 @@ package rsyncsender
 import (
 	"io"
 	"log/slog"
-	"os"
 )
 
 // rsync.h:map_struct
 type mapStruct struct {
-	fileSize      int64    // file size (from stat)
-	pOffset       int64    // window start
-	pFdOffset     int64    // offset of cursor in fd ala lseek
-	window        []byte   // window pointer
-	pSize         int64    // largest window we allocated
-	pLen          int64    // latest (rounded) window size
-	defWindowSize int64    // default window size
-	f             *os.File // file descriptor
-	err           error    // first read error
+	fileSize      int64       // file size (from stat)
+	pOffset       int64       // window start
+	pFdOffset     int64       // offset of cursor in fd ala lseek
+	window        []byte      // window pointer
+	pSize         int64       // largest window we allocated
+	pLen          int64       // latest (rounded) window size
+	defWindowSize int64       // default window size
+	f             io.ReaderAt // file contents
+	err           error       // first read error
 }
 
 const alignBoundary = 1024
@@ -29,7 +28,7 @@ func alignedOvershoot(off int64) int64 {
 	return off & (alignBoundary - 1)
 }
 
-func mapFile(f *os.File, len int64, readSize int32, blkSize int32) *mapStruct {
+func mapFile(f io.ReaderAt, len int64, readSize int32, blkSize int32) *mapStruct {
 	if blkSize > 0 && readSize%blkSize != 0 {
 		readSize += blkSize - (readSize % blkSize)
 	}
@@ -90,27 +89,22 @@ func (ms *mapStruct) ptr(offset int64, l int32) []byte {
 		slog.Debug("BUG: invalid readSize", "readSize", readSize)
 		return nil
 	}
-	if ms.pFdOffset != readStart {
-		if _, err := ms.f.Seek(readStart, io.SeekStart); err != nil {
-			slog.Error("seek error", "err", err)
-			return nil
-		}
-		ms.pFdOffset = readStart
-	}
+	ms.pFdOffset = readStart
 	ms.pOffset = windowStart
 	ms.pLen = windowSize
 	//log.Printf("-> reading %d bytes from %d into buffer at offset=%d", readSize, readStart, readOffset)
 	for readSize > 0 {
-		n, err := ms.f.Read(ms.window[readOffset : readOffset+readSize])
-		if err != nil {
+		n, err := ms.f.ReadAt(ms.window[readOffset:readOffset+readSize], ms.pFdOffset)
+		ms.pFdOffset += int64(n)
+		readOffset += int64(n)
+		readSize -= int64(n)
+		// ReadAt may return io.EOF along with the last bytes of the file.
+		if err != nil && readSize > 0 {
 			ms.err = err
 			// TODO: zero the buffer, file has changed mid-transfer
 			slog.Debug("file has changed mid-transfer")
 			return nil
 		}
-		ms.pFdOffset += int64(n)
-		readOffset += int64(n)
-		readSize -= int64(n)
 	}
 	return ms.window[alignFudge : alignFudge+len]
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/picosh/go-rsync-receiver/rsync"
 	"github.com/picosh/go-rsync-receiver/rsyncchecksum"
 	"github.com/picosh/go-rsync-receiver/rsyncopts"
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
 	"github.com/picosh/go-rsync-receiver/rsyncwire"
 	"github.com/picosh/go-rsync-receiver/utils"
 )
@@ -28,9 +29,10 @@ var (
 )
 
 // rsync/flist.c:send_file_list
-func (st *Transfer) SendFileList(opts *rsyncopts.Options, paths []string, excl *filterRuleList) (*fileList, error) {
+func (st *Transfer) SendFileList(opts *rsyncopts.Options, paths []string, excl *FilterRuleList) (*fileList, error) {
 	var fileList fileList
 	fec := &rsyncwire.Buffer{}
+	var observed []rsyncstats.File
 
 	uidMap := make(map[int32]string)
 	gidMap := make(map[int32]string)
@@ -61,10 +63,25 @@ func (st *Transfer) SendFileList(opts *rsyncopts.Options, paths []string, excl *
 			}
 			// log.Printf("flags for %q: %v", name, flags)
 
-			if excl.matches(name) {
+			if excl.Excluded(name, info.IsDir()) {
 				continue
 			}
 
+			// Read symlink targets before writing the entry: links which
+			// cannot be read are omitted from the file list.
+			var target string
+			if opts.PreserveLinks() && info.Mode().Type()&os.ModeSymlink != 0 {
+				rl, ok := st.Files.(utils.Readlinker)
+				if !ok {
+					continue
+				}
+				var err error
+				target, err = rl.Readlink(path)
+				if err != nil {
+					continue
+				}
+			}
+
 			fileList.Files = append(fileList.Files, utils.SenderFile{
 				Path:    "/",
 				Regular: info.Mode().IsRegular(),
@@ -91,7 +108,19 @@ func (st *Transfer) SendFileList(opts *rsyncopts.Options, paths []string, excl *
 			}
 			fec.WriteInt64(size)
 
-			fileList.TotalSize += size
+			// rsync/flist.c:send_file_name (directories do not count)
+			if !info.Mode().IsDir() {
+				fileList.TotalSize += size
+			}
+			st.stats.Files.Add(info.Mode())
+			if st.Observer != nil {
+				observed = append(observed, rsyncstats.File{
+					Name:    name,
+					Size:    size,
+					Mode:    info.Mode(),
+					ModTime: info.ModTime(),
+				})
+			}
 
 			// 6.   file modification time (optional, integer)
 			// TODO: this will overflow in 2038! :(
@@ -176,14 +205,23 @@ func (st *Transfer) SendFileList(opts *rsyncopts.Options, paths []string, excl *
 			if opts.PreserveLinks() && info.Mode().Type()&os.ModeSymlink != 0 {
 				// 11.  if a symbolic link and -l, the link target's length (integer)
 				// 12.  if a symbolic link and -l, the link target (byte array)
-				target, err := os.Readlink(path)
-				if err != nil {
-					continue
-				}
 				fec.WriteInt32(int32(len(target)))
 				fec.WriteString(target)
 			}
 
+			if opts.PreserveHardLinks() && info.Mode().IsRegular() {
+				// 13.  if a regular file and -H, the device and inode (long)
+				// (protocol < 28 always sends both, XMIT_SAME_DEV is unused)
+				dev, inode, ok := idevFromFileInfo(info)
+				if !ok {
+					// Without device and inode information (e.g. from
+					// object storage), each file is its own link group.
+					dev, inode = 0, int64(len(fileList.Files))
+				}
+				fec.WriteInt64(dev)
+				fec.WriteInt64(inode)
+			}
+
 			if opts.AlwaysChecksum() {
 				var emptyChecksum [rsyncchecksum.Size]byte
 				checksum := emptyChecksum[:]
@@ -229,9 +267,11 @@ func (st *Transfer) SendFileList(opts *rsyncopts.Options, paths []string, excl *
 	const ioErrors = 0
 	fec.WriteInt32(ioErrors)
 
+	st.stats.FileListSize = int64(len(fec.String()))
 	if err := st.Conn.WriteString(fec.String()); err != nil {
 		return nil, err
 	}
+	st.obs().OnFileList(observed)
 
 	return &fileList, nil
 }
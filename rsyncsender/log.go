@@ -0,0 +1,48 @@
+package rsyncsender
+
+import (
+	"io"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/rsynclog"
+)
+
+// rsync/log.c:log_item
+//
+// logItem writes the file which was just sent to the LogSink and, when
+// running as a client, to Stdout in the --out-format.
+func (st *Transfer) logItem(bytes, checksumBytes int64) error {
+	it := &rsynclog.Item{
+		Op:            "send",
+		Name:          st.cur.Name,
+		Mode:          st.cur.Mode,
+		Size:          st.cur.Size,
+		ModTime:       st.cur.ModTime,
+		Flags:         rsync.ITEM_TRANSFER,
+		Bytes:         bytes,
+		ChecksumBytes: checksumBytes,
+	}
+	if st.LogSink != nil {
+		format := st.LogFormat
+		if format == "" {
+			format = rsynclog.DefaultFormat
+		}
+		if err := st.render(st.LogSink, format, it); err != nil {
+			return err
+		}
+	}
+	if st.Stdout != nil && st.Opts.StdoutFormat() != "" {
+		return st.render(st.Stdout, st.Opts.StdoutFormat(), it)
+	}
+	return nil
+}
+
+func (st *Transfer) render(w io.Writer, format string, it *rsynclog.Item) error {
+	f := &rsynclog.Formatter{
+		Format:        format,
+		PreserveTimes: st.Opts.PreserveMTimes(),
+		LocalServer:   st.Opts.LocalServer(),
+	}
+	_, err := io.WriteString(w, f.Render(it)+"\n")
+	return err
+}
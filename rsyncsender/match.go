@@ -5,10 +5,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"hash"
-	"os"
 
 	"github.com/mmcloughlin/md4"
-	"github.com/picosh/go-rsync-receiver/nofollow"
 	"github.com/picosh/go-rsync-receiver/rsync"
 	"github.com/picosh/go-rsync-receiver/rsyncchecksum"
 	"github.com/picosh/go-rsync-receiver/utils"
@@ -22,16 +20,14 @@ type target struct {
 // rsync/match.c:hash_search
 func (st *Transfer) hashSearch(targets []target, tagTable map[uint16]int, head rsync.SumHead, fileIndex int32, fl utils.SenderFile) error {
 	st.Logger.Debug("hashSearch", "file", fl, "head", head)
-	f, err := os.OpenFile(fl.Path, os.O_RDONLY|nofollow.Maybe, 0)
+	fi, f, err := st.readFile(&fl)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	fi, err := f.Stat()
-	if err != nil {
-		return err
-	}
+	st.stats.TransferredSize += fi.Size()
+	st.startFile(fl, fi)
 
 	readSize := max(3*head.BlockLength, 256*1024)
 	ms := mapFile(f, fi.Size(), readSize, head.BlockLength)
@@ -147,7 +143,7 @@ Outer:
 				// next byte after the chunk.
 				offset += head.Sums[i].Len - 1
 				if err := readChunk(); err != nil {
-					return fmt.Errorf("readChunk: %v", err)
+					return rsync.Errorf(rsync.RERR_FILEIO, "readChunk: %w", err)
 				}
 
 				if offset >= end {
@@ -233,12 +229,10 @@ func (st *Transfer) matched(h hash.Hash, ms *mapStruct, head rsync.SumHead, offs
 	*/
 
 	if err := st.sendToken(ms, i, st.lastMatch, n); err != nil {
-		return fmt.Errorf("sendToken: %v", err)
+		return fmt.Errorf("sendToken: %w", err)
 	}
-	// TODO: data_transfer += n;
-
 	if !transmitAccumulated {
-		// stats.matched_data += s->sums[i].len;
+		st.stats.MatchedData += head.Sums[i].Len
 		n += head.Sums[i].Len
 	}
 
@@ -253,5 +247,6 @@ func (st *Transfer) matched(h hash.Hash, ms *mapStruct, head rsync.SumHead, offs
 	} else {
 		st.lastMatch = offset
 	}
+	st.obs().OnProgress(st.cur, st.lastMatch)
 	return nil
 }
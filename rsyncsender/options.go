@@ -0,0 +1,63 @@
+package rsyncsender
+
+import (
+	"io"
+
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
+	"github.com/picosh/go-rsync-receiver/rsyncwire"
+	"github.com/picosh/go-rsync-receiver/utils"
+)
+
+// Option customizes a session started by ClientRun.
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	limiter  *rsyncwire.Limiter
+	stats    *rsyncstats.TransferStats
+	observer rsyncstats.Observer
+
+	logSink   io.Writer
+	logFormat string
+
+	filters      *FilterRuleList
+	capabilities *utils.Capabilities
+}
+
+// WithLimiter limits the bandwidth of the session in both directions with l,
+// overriding the client’s --bwlimit option. Sharing l between sessions
+// enforces a combined budget, e.g. per user.
+func WithLimiter(l *rsyncwire.Limiter) Option {
+	return func(o *clientOptions) { o.limiter = l }
+}
+
+// WithStats stores the statistics of the session in stats once ClientRun
+// returns (even if the transfer failed after the file list was exchanged).
+func WithStats(stats *rsyncstats.TransferStats) Option {
+	return func(o *clientOptions) { o.stats = stats }
+}
+
+// WithObserver notifies o about the progress of the session.
+func WithObserver(o rsyncstats.Observer) Option {
+	return func(co *clientOptions) { co.observer = o }
+}
+
+// WithLogSink writes a line in format (rsynclog.DefaultFormat if empty) to w
+// for each file of the session, like rsync’s --log-file.
+func WithLogSink(w io.Writer, format string) Option {
+	return func(co *clientOptions) {
+		co.logSink = w
+		co.logFormat = format
+	}
+}
+
+// WithFilters excludes the files matching l from the session, regardless of
+// the client’s filter rules, like the filter parameters of rsyncd.conf.
+func WithFilters(l *FilterRuleList) Option {
+	return func(co *clientOptions) { co.filters = l }
+}
+
+// WithCapabilities restricts the operations of the session on its file
+// system to c: without c.AllowRead, the session is refused.
+func WithCapabilities(c *utils.Capabilities) Option {
+	return func(co *clientOptions) { co.capabilities = c }
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package rsyncsender
+
+import "io/fs"
+
+func idevFromFileInfo(fs.FileInfo) (dev int64, inode int64, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package rsyncsender
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+func idevFromFileInfo(info fs.FileInfo) (dev int64, inode int64, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int64(st.Dev), int64(st.Ino), true
+}
@@ -3,6 +3,7 @@ package rsyncsender
 // rsync/token.c:simple_send_token
 func (st *Transfer) simpleSendToken(ms *mapStruct, token int32, offset int64, n int64) error {
 	if n > 0 {
+		st.stats.LiteralData += n
 		st.Logger.Debug("sending unmatched chunks", "offset", offset, "n", n)
 		l := int64(0)
 		for l < n {
@@ -3,8 +3,11 @@ package rsyncsender
 import (
 	"io"
 	"log/slog"
+	"os"
 
+	"github.com/picosh/go-rsync-receiver/rsync"
 	"github.com/picosh/go-rsync-receiver/rsyncopts"
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
 	"github.com/picosh/go-rsync-receiver/rsyncwire"
 	"github.com/picosh/go-rsync-receiver/utils"
 )
@@ -40,10 +43,64 @@ type Transfer struct {
 	Conn      *rsyncwire.Conn
 	Seed      int32
 	lastMatch int64
+	// ioError accumulates rsync.IOERR_* flags of files which could not be
+	// sent.
+	ioError int32
+	// stats is updated while sending and returned by Do.
+	stats rsyncstats.TransferStats
+	// cur is the file currently being sent, for Observer callbacks.
+	cur rsyncstats.File
+
+	// RemoteProtocol is the protocol version announced by the client (zero
+	// if unknown). Messages introduced after ProtocolVersion are only sent
+	// to clients which understand them.
+	RemoteProtocol int32
 
 	Files utils.FS
+	// Capabilities, if non-nil, restrict the operations on Files.
+	Capabilities *utils.Capabilities
+
+	// Observer, if non-nil, is notified about the progress of the transfer.
+	Observer rsyncstats.Observer
+
+	// LogSink, if non-nil, receives a line in LogFormat (default
+	// rsynclog.DefaultFormat) for each file which is sent.
+	LogSink   io.Writer
+	LogFormat string
+
+	// Stdout, if non-nil, receives a line in the --out-format for each file
+	// which is sent. Only clients set Stdout: a server’s output is displayed
+	// by the receiving client.
+	Stdout io.Writer
 
 	Logger *slog.Logger
 }
 
 //func (rt *Transfer) listOnly() bool { return rt.Dest == "" }
+
+// protocol returns the protocol version in use for this session.
+func (st *Transfer) protocol() int32 {
+	if st.RemoteProtocol == 0 {
+		return rsync.ProtocolVersion
+	}
+	return min(st.RemoteProtocol, rsync.ProtocolVersion)
+}
+
+func (st *Transfer) obs() rsyncstats.Observer {
+	if st.Observer == nil {
+		return rsyncstats.NopObserver{}
+	}
+	return st.Observer
+}
+
+// startFile notifies the Observer that the data of fl (with file info fi) is
+// about to be sent.
+func (st *Transfer) startFile(fl utils.SenderFile, fi os.FileInfo) {
+	st.cur = rsyncstats.File{
+		Name:    fl.WPath,
+		Size:    fi.Size(),
+		Mode:    fi.Mode(),
+		ModTime: fi.ModTime(),
+	}
+	st.obs().OnFileStart(st.cur)
+}
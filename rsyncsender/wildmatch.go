@@ -0,0 +1,86 @@
+package rsyncsender
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// compileRule translates an rsync wildcard pattern into a regular expression,
+// following the slash handling of exclude.c:rule_matches.
+func compileRule(pattern string) *regexp.Regexp {
+	anchored := strings.HasPrefix(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var re strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "***") && i > 0 && pattern[i-1] == '/' &&
+				i+3 == len(pattern) {
+				// “dir/***” matches the directory and all of its contents.
+				s := re.String()
+				re.Reset()
+				re.WriteString(strings.TrimSuffix(s, "/"))
+				re.WriteString("(/.*)?")
+				i += 2
+			} else if strings.HasPrefix(pattern[i:], "**") {
+				re.WriteString(".*")
+				i++
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end == -1 {
+				re.WriteString(regexp.QuoteMeta(pattern[i:]))
+				i = len(pattern)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	prefix := "(^|/)"
+	if anchored ||
+		strings.HasPrefix(pattern, "**") ||
+		(!strings.Contains(pattern, "/") && !strings.Contains(pattern, "**")) {
+		prefix = "^"
+	}
+	compiled, err := regexp.Compile(prefix + re.String() + "$")
+	if err != nil {
+		// Invalid character classes match nothing, like in wildmatch.
+		return regexp.MustCompile(`$^`)
+	}
+	return compiled
+}
+
+// exclude.c:rule_matches
+func (fr *filterRule) matches(name string) bool {
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		return false
+	}
+	if !strings.Contains(fr.pattern, "/") &&
+		!strings.Contains(fr.pattern, "**") {
+		// If the pattern does not have any slashes AND it does not have a
+		// “**” (which could match a slash), then we just match the name
+		// portion of the path.
+		name = filepath.Base(name)
+	}
+	return fr.re.MatchString(name)
+}
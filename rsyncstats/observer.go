@@ -0,0 +1,75 @@
+package rsyncstats
+
+import (
+	"io/fs"
+	"time"
+)
+
+// File describes a file in Observer callbacks.
+type File struct {
+	Name    string
+	Size    int64
+	Mode    fs.FileMode
+	ModTime time.Time
+}
+
+// FileResult describes the outcome of transferring a single file.
+type FileResult struct {
+	LiteralData int64 // file data sent verbatim
+	MatchedData int64 // file data copied from the basis file
+	Elapsed     time.Duration
+	// Err is nil if the file was transferred successfully.
+	Err error
+}
+
+// SkipReason explains why a file was not transferred.
+type SkipReason string
+
+const (
+	SkipUpToDate   SkipReason = "up to date"
+	SkipNonRegular SkipReason = "non-regular file"
+	SkipHardLink   SkipReason = "hard-linked"
+	SkipBasisDir   SkipReason = "unchanged in basis dir"
+	SkipExcluded   SkipReason = "daemon-excluded"
+	SkipMaxSize    SkipReason = "over max-size"
+	SkipMinSize    SkipReason = "under min-size"
+	SkipPolicy     SkipReason = "refused by policy"
+)
+
+// Observer is notified about the progress of a transfer, e.g. to display
+// per-file progress or to emit audit events.
+//
+// The receiver calls the Observer from its generator and receiver goroutines,
+// so implementations must be safe for concurrent use.
+type Observer interface {
+	// OnFileList is called once the file list was received (receiver) or
+	// sent (sender).
+	OnFileList(files []File)
+
+	// OnFileStart is called before the data of f is transferred.
+	OnFileStart(f File)
+
+	// OnProgress reports the number of bytes of f transferred so far.
+	OnProgress(f File, bytes int64)
+
+	// OnFileDone is called after the data of f was transferred, or the
+	// transfer failed.
+	OnFileDone(f File, result FileResult)
+
+	// OnDelete is called for each extraneous file deleted on the receiver.
+	OnDelete(f File)
+
+	// OnSkip is called for files of the file list which are not transferred.
+	OnSkip(f File, reason SkipReason)
+}
+
+// NopObserver ignores all events. Embed it in a struct to implement only some
+// of the Observer methods.
+type NopObserver struct{}
+
+func (NopObserver) OnFileList([]File)           {}
+func (NopObserver) OnFileStart(File)            {}
+func (NopObserver) OnProgress(File, int64)      {}
+func (NopObserver) OnFileDone(File, FileResult) {}
+func (NopObserver) OnDelete(File)               {}
+func (NopObserver) OnSkip(File, SkipReason)     {}
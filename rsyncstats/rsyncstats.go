@@ -1,7 +1,170 @@
 package rsyncstats
 
+import (
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type TransferStats struct {
 	Read    int64 // total bytes read (from network connection)
 	Written int64 // total bytes written (to network connection)
 	Size    int64 // total size of files
+
+	Files            FileCounts // files in the file list
+	CreatedFiles     FileCounts // files which did not exist on the receiver
+	DeletedFiles     FileCounts // extraneous files deleted on the receiver
+	TransferredFiles int64      // regular files transferred
+	TransferredSize  int64      // total size of the transferred files
+	LiteralData      int64      // file data sent verbatim
+	MatchedData      int64      // file data copied from the basis file
+
+	FileListSize int64 // size of the encoded file list
+
+	Elapsed time.Duration // duration of the whole transfer
+	DryRun  bool
+}
+
+// FileCounts counts files by type, like rsync’s stats.num_files.
+type FileCounts struct {
+	Reg     int64
+	Dir     int64
+	Link    int64
+	Dev     int64
+	Special int64
+}
+
+// Add counts a file of the given mode.
+func (c *FileCounts) Add(mode fs.FileMode) {
+	switch {
+	case mode.IsDir():
+		c.Dir++
+	case mode&fs.ModeSymlink != 0:
+		c.Link++
+	case mode&fs.ModeDevice != 0:
+		c.Dev++
+	case mode&(fs.ModeNamedPipe|fs.ModeSocket) != 0:
+		c.Special++
+	default:
+		c.Reg++
+	}
+}
+
+// Total returns the number of files of any type.
+func (c FileCounts) Total() int64 {
+	return c.Reg + c.Dir + c.Link + c.Dev + c.Special
+}
+
+// rsync/main.c:output_itemized_counts
+func (c FileCounts) String() string {
+	var b strings.Builder
+	b.WriteString(CommaNum(c.Total()))
+	if c.Total() == 0 {
+		return b.String()
+	}
+	pre := " ("
+	for _, count := range []struct {
+		label string
+		n     int64
+	}{
+		{"reg", c.Reg},
+		{"dir", c.Dir},
+		{"link", c.Link},
+		{"dev", c.Dev},
+		{"special", c.Special},
+	} {
+		if count.n == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "%s%s: %s", pre, count.label, CommaNum(count.n))
+		pre = ", "
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// rsync/main.c:output_summary
+//
+// Summary returns the statistics in the format printed by rsync --stats (if
+// extended is true), or by rsync -v otherwise. Like rsync, the summary is
+// printed from the client’s perspective, i.e. bytes sent are the bytes
+// written by the process which collected s.
+func (s *TransferStats) Summary(extended bool) string {
+	var b strings.Builder
+	if extended {
+		b.WriteString("\n")
+		fmt.Fprintf(&b, "Number of files: %s\n", s.Files)
+		fmt.Fprintf(&b, "Number of created files: %s\n", s.CreatedFiles)
+		fmt.Fprintf(&b, "Number of deleted files: %s\n", s.DeletedFiles)
+		fmt.Fprintf(&b, "Number of regular files transferred: %s\n", CommaNum(s.TransferredFiles))
+		fmt.Fprintf(&b, "Total file size: %s bytes\n", CommaNum(s.Size))
+		fmt.Fprintf(&b, "Total transferred file size: %s bytes\n", CommaNum(s.TransferredSize))
+		fmt.Fprintf(&b, "Literal data: %s bytes\n", CommaNum(s.LiteralData))
+		fmt.Fprintf(&b, "Matched data: %s bytes\n", CommaNum(s.MatchedData))
+		fmt.Fprintf(&b, "File list size: %s\n", CommaNum(s.FileListSize))
+		fmt.Fprintf(&b, "Total bytes sent: %s\n", CommaNum(s.Written))
+		fmt.Fprintf(&b, "Total bytes received: %s\n", CommaNum(s.Read))
+	}
+
+	b.WriteString("\n")
+	total := s.Written + s.Read
+	// rsync measures the elapsed time in whole seconds.
+	elapsed := 0.5 + float64(int64(s.Elapsed/time.Second))
+	fmt.Fprintf(&b, "sent %s bytes  received %s bytes  %s bytes/sec\n",
+		CommaNum(s.Written), CommaNum(s.Read), CommaDnum(float64(total)/elapsed, 2))
+	speedup := 0.0
+	if total > 0 {
+		speedup = float64(s.Size) / float64(total)
+	}
+	suffix := ""
+	if s.DryRun {
+		suffix = " (DRY RUN)"
+	}
+	fmt.Fprintf(&b, "total size is %s  speedup is %s%s\n", CommaNum(s.Size), CommaDnum(speedup, 2), suffix)
+	return b.String()
+}
+
+// rsync/util1.c:comma_num
+//
+// CommaNum formats n with thousands separators, e.g. 1,234,567.
+func CommaNum(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	var b strings.Builder
+	if neg {
+		b.WriteByte('-')
+	}
+	for i, r := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// rsync/util1.c:comma_dnum
+//
+// CommaDnum formats f with decimals digits after the decimal point and
+// thousands separators, e.g. 1,234.57.
+func CommaDnum(f float64, decimals int) string {
+	s := strconv.FormatFloat(f, 'f', decimals, 64)
+	// The sign is handled separately, as the integer part of values between
+	// -1 and 0 has none.
+	sign := ""
+	if rest, ok := strings.CutPrefix(s, "-"); ok {
+		sign, s = "-", rest
+	}
+	intPart, frac, _ := strings.Cut(s, ".")
+	n, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil {
+		return sign + s
+	}
+	if frac == "" {
+		return sign + CommaNum(n)
+	}
+	return sign + CommaNum(n) + "." + frac
 }
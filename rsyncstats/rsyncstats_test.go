@@ -0,0 +1,70 @@
+package rsyncstats_test
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/picosh/go-rsync-receiver/rsyncstats"
+)
+
+func TestCommaNum(t *testing.T) {
+	for _, tt := range []struct {
+		n    int64
+		want string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{1000, "1,000"},
+		{1234567, "1,234,567"},
+		{-12345, "-12,345"},
+	} {
+		if got := rsyncstats.CommaNum(tt.n); got != tt.want {
+			t.Errorf("CommaNum(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestCommaDnum(t *testing.T) {
+	for _, tt := range []struct {
+		f        float64
+		decimals int
+		want     string
+	}{
+		{0, 2, "0.00"},
+		{0.5, 2, "0.50"},
+		{1234.567, 2, "1,234.57"},
+		{1234567.5, 0, "1,234,568"},
+		{-0.5, 2, "-0.50"},
+		{-1234.5, 1, "-1,234.5"},
+	} {
+		if got := rsyncstats.CommaDnum(tt.f, tt.decimals); got != tt.want {
+			t.Errorf("CommaDnum(%v, %d) = %q, want %q", tt.f, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestSummary(t *testing.T) {
+	s := &rsyncstats.TransferStats{
+		Read:             1234,
+		Written:          56789,
+		Size:             2000000,
+		TransferredFiles: 2,
+		LiteralData:      4096,
+	}
+	s.Files.Add(0644)
+	s.Files.Add(0644)
+	s.Files.Add(fs.ModeDir | 0755)
+	got := s.Summary(true)
+	for _, want := range []string{
+		"Number of files: 3 (reg: 2, dir: 1)\n",
+		"Number of deleted files: 0\n",
+		"Literal data: 4,096 bytes\n",
+		"sent 56,789 bytes  received 1,234 bytes  116,046.00 bytes/sec\n",
+		"total size is 2,000,000  speedup is 34.47\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Summary() does not contain %q:\n%s", want, got)
+		}
+	}
+}
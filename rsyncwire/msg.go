@@ -0,0 +1,131 @@
+package rsyncwire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Multiplex message tags, see rsync/rsync.h:msgcode.
+const (
+	MsgData uint8 = 0
+	// MsgError is MSG_ERROR_XFER, which protocol versions before 30 call
+	// MSG_ERROR. It is understood by all rsync versions.
+	MsgError     uint8 = 1
+	MsgErrorXfer       = MsgError
+	MsgInfo      uint8 = 2
+	MsgWarning   uint8 = 4
+	MsgLog       uint8 = 6
+	MsgClient    uint8 = 7
+	MsgRedo      uint8 = 9
+	MsgStats     uint8 = 10
+	MsgFlist     uint8 = 20
+	MsgFlistEOF  uint8 = 21
+	MsgIOError   uint8 = 22
+	MsgNoop      uint8 = 42
+	MsgSuccess   uint8 = 100
+	MsgDeleted   uint8 = 101
+	MsgNoSend    uint8 = 102
+)
+
+// MsgWriter is implemented by MultiplexWriter and BufferedMultiplexWriter.
+type MsgWriter interface {
+	WriteMsg(tag uint8, p []byte) (int, error)
+}
+
+// rsync/io.c:send_msg_int
+func sendMsgInt(w MsgWriter, tag uint8, v int32) error {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], uint32(v))
+	_, err := w.WriteMsg(tag, buf[:])
+	return err
+}
+
+// DecodeMsgInt decodes the payload of an integer message (MsgRedo,
+// MsgFlist, MsgIOError, MsgSuccess or MsgNoSend).
+func DecodeMsgInt(payload []byte) (int32, error) {
+	if len(payload) != 4 {
+		return 0, fmt.Errorf("invalid message length %d, want 4", len(payload))
+	}
+	return int32(binary.LittleEndian.Uint32(payload)), nil
+}
+
+// SendInfo, SendWarning, SendError, SendLog and SendClient send a text
+// message, which is displayed (or logged) by the peer.
+func SendInfo(w MsgWriter, msg string) error    { return sendMsgText(w, MsgInfo, msg) }
+func SendWarning(w MsgWriter, msg string) error { return sendMsgText(w, MsgWarning, msg) }
+func SendError(w MsgWriter, msg string) error   { return sendMsgText(w, MsgError, msg) }
+func SendLog(w MsgWriter, msg string) error     { return sendMsgText(w, MsgLog, msg) }
+func SendClient(w MsgWriter, msg string) error  { return sendMsgText(w, MsgClient, msg) }
+
+func sendMsgText(w MsgWriter, tag uint8, msg string) error {
+	_, err := w.WriteMsg(tag, []byte(msg))
+	return err
+}
+
+// SendRedo asks the generator to reprocess file list entry ndx.
+func SendRedo(w MsgWriter, ndx int32) error { return sendMsgInt(w, MsgRedo, ndx) }
+
+// SendFlist announces that the file list segment for directory ndx follows.
+func SendFlist(w MsgWriter, ndx int32) error { return sendMsgInt(w, MsgFlist, ndx) }
+
+// SendFlistEOF announces that no more file list segments follow.
+func SendFlistEOF(w MsgWriter) error {
+	_, err := w.WriteMsg(MsgFlistEOF, nil)
+	return err
+}
+
+// SendIOError reports an I/O error (rsync.IOERR_* flags) to the peer.
+func SendIOError(w MsgWriter, flags int32) error { return sendMsgInt(w, MsgIOError, flags) }
+
+// SendNoop sends an empty message, which keeps the connection alive.
+func SendNoop(w MsgWriter) error {
+	_, err := w.WriteMsg(MsgNoop, nil)
+	return err
+}
+
+// SendSuccess reports that file list entry ndx was updated successfully.
+func SendSuccess(w MsgWriter, ndx int32) error { return sendMsgInt(w, MsgSuccess, ndx) }
+
+// SendNoSend reports that file list entry ndx could not be opened by the
+// sender.
+func SendNoSend(w MsgWriter, ndx int32) error { return sendMsgInt(w, MsgNoSend, ndx) }
+
+// rsync/log.c:log_delete
+//
+// SendDeleted reports the deletion of name to the peer. Directories are
+// marked by a trailing NUL byte.
+func SendDeleted(w MsgWriter, name string, isDir bool) error {
+	payload := []byte(name)
+	if isDir {
+		payload = append(payload, 0)
+	}
+	_, err := w.WriteMsg(MsgDeleted, payload)
+	return err
+}
+
+// DecodeDeleted decodes the payload of a MsgDeleted message.
+func DecodeDeleted(payload []byte) (name string, isDir bool) {
+	name = string(payload)
+	if strings.HasSuffix(name, "\x00") {
+		return strings.TrimSuffix(name, "\x00"), true
+	}
+	return name, false
+}
+
+// SendStats sends the total number of bytes read, which the receiver
+// reports to its generator after the transfer.
+func SendStats(w MsgWriter, totalRead int64) error {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(totalRead))
+	_, err := w.WriteMsg(MsgStats, buf[:])
+	return err
+}
+
+// DecodeStats decodes the payload of a MsgStats message.
+func DecodeStats(payload []byte) (totalRead int64, _ error) {
+	if len(payload) != 8 {
+		return 0, fmt.Errorf("invalid message length %d, want 8", len(payload))
+	}
+	return int64(binary.LittleEndian.Uint64(payload)), nil
+}
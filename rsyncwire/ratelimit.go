@@ -0,0 +1,114 @@
+package rsyncwire
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket rate limiter for --bwlimit. A Limiter is safe for
+// concurrent use, so a single Limiter can be shared between sessions, e.g. to
+// enforce a per-user bandwidth budget.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a Limiter which allows bytesPerSecond on average.
+func NewLimiter(bytesPerSecond int64) *Limiter {
+	l := &Limiter{}
+	l.SetRate(bytesPerSecond)
+	l.tokens = float64(l.burst)
+	return l
+}
+
+// SetRate changes the rate of l, taking effect for subsequent I/O.
+func (l *Limiter) SetRate(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = float64(bytesPerSecond)
+	// Transfer at most a tenth of a second worth of data at once, so that
+	// the rate stays smooth, like rsync/io.c:sleep_for_bwlimit.
+	l.burst = min(max(int(bytesPerSecond/10), 1024), ioBufferSize)
+	l.tokens = min(l.tokens, float64(l.burst))
+}
+
+// wait takes n tokens from the bucket, sleeping until they are available.
+func (l *Limiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, float64(l.burst))
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 && l.rate > 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+func (l *Limiter) chunkSize() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.burst
+}
+
+// Reader returns a reader which limits reads from r to the rate of l.
+func (l *Limiter) Reader(r io.Reader) io.Reader {
+	return &limitedReader{r: r, l: l}
+}
+
+// Writer returns a writer which limits writes to w to the rate of l.
+func (l *Limiter) Writer(w io.Writer) io.Writer {
+	return &limitedWriter{w: w, l: l}
+}
+
+type limitedReader struct {
+	r io.Reader
+	l *Limiter
+}
+
+func (r *limitedReader) Read(p []byte) (n int, err error) {
+	if chunk := r.l.chunkSize(); len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err = r.r.Read(p)
+	r.l.wait(n)
+	return n, err
+}
+
+type limitedWriter struct {
+	w io.Writer
+	l *Limiter
+}
+
+func (w *limitedWriter) Write(p []byte) (n int, err error) {
+	for n < len(p) {
+		chunk := p[n:]
+		if size := w.l.chunkSize(); len(chunk) > size {
+			chunk = chunk[:size]
+		}
+		w.l.wait(len(chunk))
+		written, err := w.w.Write(chunk)
+		n += written
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// LimitPair wraps the reader and writer of a CounterPair with l. The
+// counters keep counting all bytes, l merely delays them.
+func LimitPair(l *Limiter, crd *CountingReader, cwr *CountingWriter) {
+	crd.R = l.Reader(crd.R)
+	cwr.W = l.Writer(cwr.W)
+}
@@ -0,0 +1,141 @@
+package rsyncwire
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TimeoutError is returned when the peer did not accept or send any data
+// within the I/O timeout (--timeout).
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("io timeout after %d seconds -- exiting", int(e.Timeout/time.Second))
+}
+
+// Unwrap returns os.ErrDeadlineExceeded, which rsync.ExitCode maps to
+// RERR_TIMEOUT.
+func (e *TimeoutError) Unwrap() error { return os.ErrDeadlineExceeded }
+
+type deadliner interface {
+	SetReadDeadline(time.Time) error
+	SetWriteDeadline(time.Time) error
+}
+
+// rsync/io.c:check_timeout
+//
+// timeoutReadWriter enforces an I/O timeout on Read and Write calls. Like
+// rsync, it measures the time since the last I/O in either direction, so that
+// a blocked Read does not time out while Write calls make progress (e.g. the
+// generator sends checksums while the receiver waits for file data).
+type timeoutReadWriter struct {
+	rw      io.ReadWriter
+	timeout time.Duration
+	expired atomic.Bool
+
+	mu      sync.Mutex
+	pending int         // Read and Write calls in progress
+	timer   *time.Timer // closes rw if it does not support deadlines
+}
+
+// WithTimeout returns an io.ReadWriter which fails reads and writes on rw
+// with a *TimeoutError when no data was read or written for timeout.
+//
+// Transports with deadline support (e.g. net.Conn or *os.File pipes) use
+// read and write deadlines. For other transports which implement io.Closer
+// (e.g. SSH channels), the transport is closed when the timeout expires, as
+// there is no other way to interrupt a blocked call. Other transports are
+// returned unchanged.
+func WithTimeout(rw io.ReadWriter, timeout time.Duration) io.ReadWriter {
+	if timeout <= 0 {
+		return rw
+	}
+	_, hasDeadlines := rw.(deadliner)
+	_, isCloser := rw.(io.Closer)
+	if !hasDeadlines && !isCloser {
+		return rw
+	}
+	return &timeoutReadWriter{rw: rw, timeout: timeout}
+}
+
+func (t *timeoutReadWriter) Read(p []byte) (n int, err error) {
+	return t.do(func(d deadliner, deadline time.Time) error {
+		return d.SetReadDeadline(deadline)
+	}, func() (int, error) {
+		return t.rw.Read(p)
+	})
+}
+
+func (t *timeoutReadWriter) Write(p []byte) (n int, err error) {
+	return t.do(func(d deadliner, deadline time.Time) error {
+		return d.SetWriteDeadline(deadline)
+	}, func() (int, error) {
+		return t.rw.Write(p)
+	})
+}
+
+func (t *timeoutReadWriter) do(setDeadline func(deadliner, time.Time) error, op func() (int, error)) (int, error) {
+	if err := t.begin(setDeadline); err != nil {
+		return 0, err
+	}
+	n, err := op()
+	t.end(n > 0)
+	if err != nil && (t.expired.Load() || errors.Is(err, os.ErrDeadlineExceeded)) {
+		return n, &TimeoutError{Timeout: t.timeout}
+	}
+	return n, err
+}
+
+// begin arms the timeout for a Read or Write call.
+func (t *timeoutReadWriter) begin(setDeadline func(deadliner, time.Time) error) error {
+	if d, ok := t.rw.(deadliner); ok {
+		return setDeadline(d, time.Now().Add(t.timeout))
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending++
+	if t.timer == nil {
+		t.timer = time.AfterFunc(t.timeout, t.expire)
+	} else {
+		t.timer.Reset(t.timeout)
+	}
+	return nil
+}
+
+// end disarms the timeout of a finished Read or Write call. If the call
+// transferred data, the timeout of all other pending calls restarts.
+func (t *timeoutReadWriter) end(active bool) {
+	if d, ok := t.rw.(deadliner); ok {
+		if active {
+			deadline := time.Now().Add(t.timeout)
+			d.SetReadDeadline(deadline)
+			d.SetWriteDeadline(deadline)
+		}
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending--
+	if t.pending == 0 {
+		t.timer.Stop()
+	} else if active {
+		t.timer.Reset(t.timeout)
+	}
+}
+
+func (t *timeoutReadWriter) expire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending == 0 {
+		return
+	}
+	t.expired.Store(true)
+	t.rw.(io.Closer).Close()
+}
@@ -1,21 +1,28 @@
 package rsyncwire
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"log/slog"
-)
-
-const (
-	MsgData  uint8 = 0
-	MsgInfo  uint8 = 2
-	MsgError uint8 = 1
+	"sync"
+	"time"
 )
 
 const mplexBase = 7
 
+// maxFrameLength is the largest payload which fits into the 24-bit length
+// field of a multiplex header.
+const maxFrameLength = 0x00FFFFFF
+
+// Flusher is implemented by writers which buffer data, such as
+// BufferedMultiplexWriter.
+type Flusher interface {
+	Flush() error
+}
+
 type MultiplexWriter struct {
 	Writer io.Writer
 }
@@ -25,17 +32,182 @@ func (w *MultiplexWriter) Write(p []byte) (n int, err error) {
 }
 
 func (w *MultiplexWriter) WriteMsg(tag uint8, p []byte) (n int, err error) {
-	header := uint32(mplexBase+tag)<<24 | uint32(len(p))
-	// log.Printf("len %d (hex %x)", len(p), uint32(len(p)))
-	// log.Printf("header=%v (%x)", header, header)
-	if err := binary.Write(w.Writer, binary.LittleEndian, header); err != nil {
+	for {
+		chunk := p[n:]
+		if len(chunk) > maxFrameLength {
+			chunk = chunk[:maxFrameLength]
+		}
+		header := uint32(mplexBase+tag)<<24 | uint32(len(chunk))
+		// log.Printf("len %d (hex %x)", len(chunk), uint32(len(chunk)))
+		// log.Printf("header=%v (%x)", header, header)
+		if err := binary.Write(w.Writer, binary.LittleEndian, header); err != nil {
+			return n, err
+		}
+		written, err := w.Writer.Write(chunk)
+		n += written
+		if err != nil || n == len(p) {
+			return n, err
+		}
+	}
+}
+
+// BufferedMultiplexWriter is a MultiplexWriter which coalesces data writes
+// into frames of up to ioBufferSize bytes, like rsync/io.c does with its
+// output buffer. Buffered data is written when the buffer is full, when Flush
+// is called, and before any out-of-band message, so that messages stay
+// ordered with respect to the data.
+//
+// BufferedMultiplexWriter is safe for concurrent use.
+type BufferedMultiplexWriter struct {
+	Writer io.Writer
+
+	mu sync.Mutex
+	// buf holds a frame: 4 bytes of header space, followed by the payload.
+	buf       []byte
+	lastWrite time.Time
+}
+
+func (w *BufferedMultiplexWriter) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.buf == nil {
+		w.buf = make([]byte, 4, 4+ioBufferSize)
+	}
+	for n < len(p) {
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flushLocked(); err != nil {
+				return n, err
+			}
+		}
+		written := copy(w.buf[len(w.buf):cap(w.buf)], p[n:])
+		w.buf = w.buf[:len(w.buf)+written]
+		n += written
+	}
+	return n, nil
+}
+
+// WriteMsg writes p with the given tag. Data is buffered like with Write,
+// all other messages are written immediately, after any buffered data.
+//
+// An empty data message is written as an empty frame, which older peers
+// accept as a keepalive.
+func (w *BufferedMultiplexWriter) WriteMsg(tag uint8, p []byte) (n int, err error) {
+	if tag == MsgData && len(p) > 0 {
+		return w.Write(p)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.flushLocked(); err != nil {
+		return 0, err
+	}
+	w.lastWrite = time.Now()
+	return (&MultiplexWriter{Writer: w.Writer}).WriteMsg(tag, p)
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (w *BufferedMultiplexWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flushLocked()
+}
+
+func (w *BufferedMultiplexWriter) flushLocked() error {
+	if len(w.buf) <= 4 {
+		return nil
+	}
+	header := uint32(mplexBase+MsgData)<<24 | uint32(len(w.buf)-4)
+	binary.LittleEndian.PutUint32(w.buf[:4], header)
+	_, err := w.Writer.Write(w.buf)
+	w.buf = w.buf[:4]
+	w.lastWrite = time.Now()
+	return err
+}
+
+// rsync/io.c:maybe_send_keepalive
+//
+// Keepalive starts sending a keepalive message whenever nothing was written
+// for half of timeout, so that the peer does not run into its I/O timeout
+// while we are busy (e.g. computing checksums). MSG_NOOP is only understood
+// by protocol 30 peers, so older peers get an empty data frame instead.
+// Keepalives are sent until stop is called.
+func (w *BufferedMultiplexWriter) Keepalive(timeout time.Duration, noop bool) (stop func()) {
+	lull := timeout / 2
+	ticker := time.NewTicker(max(lull/2, 100*time.Millisecond))
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			w.mu.Lock()
+			idle := time.Since(w.lastWrite)
+			w.mu.Unlock()
+			if idle < lull {
+				continue
+			}
+			tag := MsgData
+			if noop {
+				tag = MsgNoop
+			}
+			if _, err := w.WriteMsg(tag, nil); err != nil {
+				return // the next regular write will return the error
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}
+}
+
+// FlushingReader flushes W before every read from R, so that the peer has
+// received all of our pending output before we block waiting for its reply.
+//
+// Only use a FlushingReader when the same goroutine reads and writes: if
+// another goroutine writes to W, flushing could block on the peer, which in
+// turn might be blocked on us reading.
+type FlushingReader struct {
+	R io.Reader
+	W Flusher
+}
+
+func (r *FlushingReader) Read(p []byte) (n int, err error) {
+	if err := r.W.Flush(); err != nil {
 		return 0, err
 	}
-	return w.Writer.Write(p)
+	return r.R.Read(p)
 }
 
+// MsgHandler is called for each out-of-band message (any tag other than
+// MsgData) received by a MultiplexReader. Returning an error aborts the read.
+type MsgHandler func(tag uint8, payload []byte) error
+
+// MultiplexReader demultiplexes the data stream from out-of-band messages.
+// Data frames are retained, so that reads of any size can be served.
+//
+// Once a MultiplexReader was used, Reader must not be read from directly, as
+// the MultiplexReader buffers input.
 type MultiplexReader struct {
 	Reader io.Reader
+
+	// Handler is called for out-of-band messages. If nil, info, warning and
+	// log messages are logged, error messages are returned as errors and
+	// other known messages are ignored.
+	Handler MsgHandler
+
+	// Frames is the number of frames read, DataBytes the number of data
+	// bytes received and Messages the number of out-of-band messages by tag.
+	Frames    int64
+	DataBytes int64
+	Messages  map[uint8]int64
+
+	br  *bufio.Reader
+	buf []byte // unread data of the current data frame
 }
 
 // rsync.h defines IO_BUFFER_SIZE as 32 * 1024, but gokr-rsyncd increases it to
@@ -45,13 +217,16 @@ const ioBufferSize = 256 * 1024
 const maxMessageSize = ioBufferSize
 
 func (w *MultiplexReader) ReadMsg() (tag uint8, p []byte, err error) {
-	var header uint32
-	if err := binary.Read(w.Reader, binary.LittleEndian, &header); err != nil {
+	if w.br == nil {
+		w.br = bufio.NewReaderSize(w.Reader, 4+ioBufferSize)
+	}
+	var header [4]byte
+	if _, err := io.ReadFull(w.br, header[:]); err != nil {
 		return 0, nil, err
 	}
 
-	tag = uint8(header>>24) - mplexBase
-	length := header & 0x00FFFFFF
+	tag = header[3] - mplexBase
+	length := binary.LittleEndian.Uint32(header[:]) & 0x00FFFFFF
 	if length > maxMessageSize {
 		// NOTE: if you run into this error, one alternative to bumping
 		// maxMessageSize is to restructure the program to work with i/o buffer
@@ -59,32 +234,59 @@ func (w *MultiplexReader) ReadMsg() (tag uint8, p []byte, err error) {
 		return 0, nil, fmt.Errorf("length %d exceeds max message size (%d)", length, maxMessageSize)
 	}
 	p = make([]byte, int(length))
-	if _, err := io.ReadFull(w.Reader, p); err != nil {
+	if _, err := io.ReadFull(w.br, p); err != nil {
 		return 0, nil, err
 	}
 	// log.Printf("header=%v (%x), tag=%v, length=%v", header, header, tag, length)
 	// log.Printf("payload=%x / %q", p, p)
+	w.Frames++
+	if tag == MsgData {
+		w.DataBytes += int64(length)
+	} else {
+		if w.Messages == nil {
+			w.Messages = make(map[uint8]int64)
+		}
+		w.Messages[tag]++
+	}
 	return tag, p, nil
 }
 
 func (w *MultiplexReader) Read(p []byte) (n int, err error) {
-	tag, payload, err := w.ReadMsg()
-	if err != nil {
-		return 0, err
-	}
-	if tag == MsgError {
-		return 0, fmt.Errorf("%s", payload)
+	if len(p) == 0 {
+		return 0, nil
 	}
-	if tag == MsgInfo {
-		slog.Debug("info", "payload", payload)
+	for len(w.buf) == 0 {
+		tag, payload, err := w.ReadMsg()
+		if err != nil {
+			return 0, err
+		}
+		if tag == MsgData {
+			w.buf = payload
+			continue
+		}
+		if err := w.handleMsg(tag, payload); err != nil {
+			return 0, err
+		}
 	}
-	if tag != MsgData {
-		return 0, fmt.Errorf("unexpected tag: got %v, want %v", tag, MsgData)
+	n = copy(p, w.buf)
+	w.buf = w.buf[n:]
+	return n, nil
+}
+
+func (w *MultiplexReader) handleMsg(tag uint8, payload []byte) error {
+	if w.Handler != nil {
+		return w.Handler(tag, payload)
 	}
-	if len(p) < len(payload) {
-		panic(fmt.Sprintf("not enough buffer space! %d < %d", len(p), len(payload)))
+	switch tag {
+	case MsgError:
+		return fmt.Errorf("%s", payload)
+	case MsgInfo, MsgWarning, MsgLog, MsgClient:
+		slog.Debug("message", "tag", tag, "payload", payload)
+	case MsgDeleted, MsgSuccess, MsgNoSend, MsgNoop, MsgIOError:
+	default:
+		return fmt.Errorf("unexpected tag: got %v, want %v", tag, MsgData)
 	}
-	return copy(p, payload), nil
+	return nil
 }
 
 type Buffer struct {
@@ -92,8 +294,8 @@ type Buffer struct {
 	buf bytes.Buffer
 }
 
-func (b *Buffer) WriteByte(data byte) {
-	binary.Write(&b.buf, binary.LittleEndian, data)
+func (b *Buffer) WriteByte(data byte) error {
+	return b.buf.WriteByte(data)
 }
 
 func (b *Buffer) WriteInt32(data int32) {
@@ -124,6 +326,14 @@ type Conn struct {
 	Reader io.Reader
 }
 
+// Flush writes any data buffered by Writer to the connection.
+func (c *Conn) Flush() error {
+	if f, ok := c.Writer.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
 func (c *Conn) WriteByte(data byte) error {
 	return binary.Write(c.Writer, binary.LittleEndian, data)
 }
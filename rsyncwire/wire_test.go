@@ -0,0 +1,210 @@
+package rsyncwire_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/rsyncwire"
+)
+
+func TestMultiplexRoundTrip(t *testing.T) {
+	var wire bytes.Buffer
+	mpx := &rsyncwire.BufferedMultiplexWriter{Writer: &wire}
+	w := &rsyncwire.Conn{Writer: mpx}
+	if err := w.WriteInt32(42); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mpx.WriteMsg(rsyncwire.MsgInfo, []byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	large := bytes.Repeat([]byte{0xaa}, 300*1024)
+	if _, err := w.Writer.Write(large); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var infos []string
+	mr := &rsyncwire.MultiplexReader{
+		Reader: &wire,
+		Handler: func(tag uint8, payload []byte) error {
+			if tag == rsyncwire.MsgInfo {
+				infos = append(infos, string(payload))
+			}
+			return nil
+		},
+	}
+	r := &rsyncwire.Conn{Reader: mr}
+	got, err := r.ReadInt32()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 42 {
+		t.Errorf("ReadInt32() = %d, want 42", got)
+	}
+	// Read with a buffer smaller than the frame size.
+	var rest bytes.Buffer
+	if _, err := io.CopyBuffer(&rest, struct{ io.Reader }{mr}, make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest.Bytes(), large) {
+		t.Errorf("received %d bytes of data, want %d", rest.Len(), len(large))
+	}
+	if len(infos) != 1 || infos[0] != "hello\n" {
+		t.Errorf("info messages = %q, want [hello]", infos)
+	}
+
+	// The info message flushes the int, and large is split into two frames.
+	if got, want := mr.Frames, int64(4); got != want {
+		t.Errorf("Frames = %d, want %d", got, want)
+	}
+	if got, want := mr.DataBytes, int64(4+len(large)); got != want {
+		t.Errorf("DataBytes = %d, want %d", got, want)
+	}
+}
+
+func TestMultiplexReaderError(t *testing.T) {
+	var wire bytes.Buffer
+	mpx := &rsyncwire.MultiplexWriter{Writer: &wire}
+	if _, err := mpx.WriteMsg(rsyncwire.MsgError, []byte("remote failure")); err != nil {
+		t.Fatal(err)
+	}
+	mr := &rsyncwire.MultiplexReader{Reader: &wire}
+	_, err := mr.Read(make([]byte, 1))
+	if err == nil || err.Error() != "remote failure" {
+		t.Errorf("Read() = %v, want remote failure", err)
+	}
+}
+
+func TestMsgEncoding(t *testing.T) {
+	var wire bytes.Buffer
+	mpx := &rsyncwire.MultiplexWriter{Writer: &wire}
+	if err := rsyncwire.SendDeleted(mpx, "dir/sub", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := rsyncwire.SendIOError(mpx, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	mr := &rsyncwire.MultiplexReader{Reader: &wire}
+	tag, payload, err := mr.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, isDir := rsyncwire.DecodeDeleted(payload); tag != rsyncwire.MsgDeleted || name != "dir/sub" || !isDir {
+		t.Errorf("got tag %d, name %q, isDir %v, want MsgDeleted dir/sub/", tag, name, isDir)
+	}
+	tag, payload, err = mr.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags, err := rsyncwire.DecodeMsgInt(payload); tag != rsyncwire.MsgIOError || err != nil || flags != 2 {
+		t.Errorf("got tag %d, flags %d (%v), want MsgIOError 2", tag, flags, err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	rw := rsyncwire.WithTimeout(local, 50*time.Millisecond)
+	_, err := rw.Read(make([]byte, 1))
+	var te *rsyncwire.TimeoutError
+	if !errors.As(err, &te) {
+		t.Fatalf("Read() = %v, want *TimeoutError", err)
+	}
+	if got, want := rsync.ExitCode(err), int(rsync.RERR_TIMEOUT); got != want {
+		t.Errorf("ExitCode() = %d, want %d", got, want)
+	}
+}
+
+func TestTimeoutActivity(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		wrap func(net.Conn) io.ReadWriter
+	}{
+		{"Deadlines", func(c net.Conn) io.ReadWriter { return c }},
+		// Hide the deadline methods so that the transport is closed instead.
+		{"Close", func(c net.Conn) io.ReadWriter { return struct{ io.ReadWriteCloser }{c} }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			local, remote := net.Pipe()
+			defer remote.Close()
+			go io.Copy(io.Discard, remote)
+			const timeout = 100 * time.Millisecond
+			rw := rsyncwire.WithTimeout(tt.wrap(local), timeout)
+
+			// A Read blocked for longer than the timeout succeeds as long as
+			// Writes make progress in the meantime.
+			go func() {
+				for range 6 {
+					time.Sleep(timeout / 2)
+					if _, err := rw.Write([]byte{1}); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+				remote.Write([]byte{2})
+			}()
+			if _, err := rw.Read(make([]byte, 1)); err != nil {
+				t.Fatalf("Read() = %v, want success", err)
+			}
+
+			var te *rsyncwire.TimeoutError
+			if _, err := rw.Read(make([]byte, 1)); !errors.As(err, &te) {
+				t.Errorf("idle Read() = %v, want *TimeoutError", err)
+			}
+		})
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	for _, tt := range []struct {
+		noop bool
+		tag  uint8
+	}{
+		{false, rsyncwire.MsgData},
+		{true, rsyncwire.MsgNoop},
+	} {
+		local, remote := net.Pipe()
+		mpx := &rsyncwire.BufferedMultiplexWriter{Writer: local}
+		start := time.Now()
+		stop := mpx.Keepalive(200*time.Millisecond, tt.noop)
+		mr := &rsyncwire.MultiplexReader{Reader: remote}
+		tag, payload, err := mr.ReadMsg()
+		stop()
+		local.Close()
+		remote.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tag != tt.tag || len(payload) != 0 {
+			t.Errorf("Keepalive(noop=%v) sent tag %d with %d bytes, want tag %d without payload", tt.noop, tag, len(payload), tt.tag)
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("Keepalive(noop=%v) sent a message after %v, before half of the timeout", tt.noop, elapsed)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := rsyncwire.NewLimiter(100 * 1024)
+	var out bytes.Buffer
+	w := l.Writer(&out)
+	start := time.Now()
+	// The first burst is free, the remaining 20 KiB take about 200ms.
+	if _, err := w.Write(make([]byte, 30*1024)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("writing 30 KiB at 100 KiB/s took only %v", elapsed)
+	}
+	if out.Len() != 30*1024 {
+		t.Errorf("wrote %d bytes, want %d", out.Len(), 30*1024)
+	}
+}
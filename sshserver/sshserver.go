@@ -0,0 +1,246 @@
+// Package sshserver accepts rsync transfers via SSH (rsync -e ssh), without
+// an SSH daemon or shell: it handles exec requests for “rsync --server …” by
+// running the server side of the transfer over the SSH channel.
+package sshserver
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/picosh/go-rsync-receiver/rsync"
+	"github.com/picosh/go-rsync-receiver/rsyncd"
+	"github.com/picosh/go-rsync-receiver/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+// userExtension is the ssh.Permissions extension which carries the user
+// returned by Authenticate.
+const userExtension = "rsync-user"
+
+// Server serves rsync transfers to SSH clients.
+type Server struct {
+	// HostKeys are the keys with which the server identifies itself.
+	HostKeys []ssh.Signer
+
+	// Authenticate returns the user which key (offered by the client
+	// described by meta) belongs to, or an error to reject the key.
+	Authenticate func(meta ssh.ConnMetadata, key ssh.PublicKey) (user string, err error)
+
+	// FS returns the file system of user’s transfers, rooted at dir: dir is
+	// empty when the user downloads files (which are named relative to the
+	// file system’s root), and the destination directory (a clean, absolute
+	// path) when the user uploads files.
+	FS func(user, dir string) (utils.FS, error)
+
+	// Modules, if not nil, serves its modules instead of FS: clients address
+	// files as host:module/dir, and the module’s policies apply to user.
+	Modules *rsyncd.Server
+
+	Logger *slog.Logger
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
+}
+
+func (s *Server) config() *ssh.ServerConfig {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if s.Authenticate == nil {
+				return nil, errors.New("no authentication configured")
+			}
+			user, err := s.Authenticate(meta, key)
+			if err != nil {
+				return nil, err
+			}
+			return &ssh.Permissions{
+				Extensions: map[string]string{userExtension: user},
+			}, nil
+		},
+	}
+	for _, key := range s.HostKeys {
+		config.AddHostKey(key)
+	}
+	return config
+}
+
+// Serve accepts SSH connections on ln and handles each in a new goroutine.
+func (s *Server) Serve(ln net.Listener) error {
+	config := s.config()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := s.handleConn(conn, config); err != nil {
+				s.logger().Error("ssh connection", "remote", conn.RemoteAddr(), "err", err)
+			}
+		}()
+	}
+}
+
+// HandleConn runs the SSH protocol on conn until the client disconnects. The
+// caller must close conn afterwards.
+func (s *Server) HandleConn(conn net.Conn) error {
+	return s.handleConn(conn, s.config())
+}
+
+func (s *Server) handleConn(conn net.Conn, config *ssh.ServerConfig) error {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return err
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	user := sconn.Permissions.Extensions[userExtension]
+	logger := s.logger().With("remote", sconn.RemoteAddr(), "user", user)
+	logger.Info("ssh connection", "client", string(sconn.ClientVersion()))
+
+	var wg sync.WaitGroup
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			logger.Error("accepting channel", "err", err)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer channel.Close()
+			s.session(logger, user, channel, requests)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// session serves the requests of a session channel until the first exec
+// request, whose command it runs.
+func (s *Server) session(logger *slog.Logger, user string, channel ssh.Channel, requests <-chan *ssh.Request) {
+	for req := range requests {
+		if req.Type != "exec" {
+			// Neither shells nor terminals are offered, and environment
+			// variables are of no use to rsync.
+			req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+		go ssh.DiscardRequests(requests)
+
+		logger.Info("exec", "command", payload.Command)
+		err := s.exec(logger, user, channel, payload.Command)
+		if err != nil {
+			logger.Error("rsync", "err", err)
+			fmt.Fprintf(channel.Stderr(), "rsync: %v\n", err)
+		}
+		status := struct{ Status uint32 }{uint32(rsync.ExitCode(err))}
+		channel.SendRequest("exit-status", false, ssh.Marshal(&status))
+		return
+	}
+}
+
+// exec runs command, which must be an rsync --server command line, for user
+// over channel.
+func (s *Server) exec(logger *slog.Logger, user string, channel ssh.Channel, command string) error {
+	args, err := splitCommand(command)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 || !strings.HasSuffix(path.Base(args[0]), "rsync") {
+		return rsync.Errorf(rsync.RERR_UNSUPPORTED, "only rsync commands are supported")
+	}
+	args = args[1:]
+	if s.Modules != nil {
+		return s.Modules.HandleServer(channel, user, args)
+	}
+	if s.FS == nil {
+		return rsync.Errorf(rsync.RERR_FILESELECT, "no file system configured")
+	}
+
+	opts, paths, err := rsyncd.ParseServerArgs(args)
+	if err != nil {
+		return err
+	}
+	var dir string
+	if !opts.Sender() {
+		var dest string
+		if len(paths) > 0 {
+			dest = paths[0]
+		}
+		dir = path.Clean("/" + dest)
+	}
+	filesystem, err := s.FS(user, dir)
+	if err != nil {
+		return rsync.NewError(rsync.RERR_FILESELECT, err)
+	}
+	return rsyncd.RunServer(logger, opts, paths, channel, filesystem, true)
+}
+
+// splitCommand splits the command line of an exec request into words, like a
+// POSIX shell would (without expansions): the rsync client quotes file names
+// with single quotes or backslashes.
+func splitCommand(command string) ([]string, error) {
+	var (
+		words []string
+		word  strings.Builder
+		quote byte
+		in    bool
+	)
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case quote == '\'' && c == '\'':
+			quote = 0
+		case quote == '"' && c == '"':
+			quote = 0
+		case quote == '"' && c == '\\' && i+1 < len(command) && strings.IndexByte(`"\$`+"`", command[i+1]) >= 0:
+			i++
+			word.WriteByte(command[i])
+		case quote != 0:
+			word.WriteByte(c)
+		case c == '\'' || c == '"':
+			quote = c
+			in = true
+		case c == '\\' && i+1 < len(command):
+			i++
+			word.WriteByte(command[i])
+			in = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if in {
+				words = append(words, word.String())
+				word.Reset()
+				in = false
+			}
+		default:
+			word.WriteByte(c)
+			in = true
+		}
+	}
+	if quote != 0 {
+		return nil, rsync.Errorf(rsync.RERR_SYNTAX, "missing trailing-%c in command", quote)
+	}
+	if in {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
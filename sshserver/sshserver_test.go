@@ -0,0 +1,187 @@
+package sshserver_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/picosh/go-rsync-receiver/localfs"
+	"github.com/picosh/go-rsync-receiver/rsyncclient"
+	"github.com/picosh/go-rsync-receiver/rsyncopts"
+	"github.com/picosh/go-rsync-receiver/sshserver"
+	"github.com/picosh/go-rsync-receiver/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func clientConfig(key ssh.Signer) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            "rsync",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(key)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+}
+
+// sshConn is the connection to an rsync server started via ssh.
+type sshConn struct {
+	io.Reader
+	io.Writer
+}
+
+// transfer runs rsync args against the server at addr, authenticating with
+// key, and returns the server’s stderr and the exit status.
+func transfer(t *testing.T, addr string, key ssh.Signer, args, local, remote string, push bool) (string, int) {
+	t.Helper()
+	pc, err := rsyncopts.ParseArguments(strings.Fields(args), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := pc.Options
+	if push {
+		opts.SetSender()
+	}
+	client, err := ssh.Dial("tcp", addr, clientConfig(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	command := "rsync " + strings.Join(rsyncclient.ServerArgs(opts, []string{remote}), " ")
+	if err := session.Start(command); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	if push {
+		names = []string{"."}
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	rsyncclient.Run(logger, opts, sshConn{stdout, stdin}, localfs.New(local), names,
+		rsyncclient.WithOutput(io.Discard, io.Discard))
+	stdin.Close()
+	err = session.Wait()
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return stderr.String(), exitErr.ExitStatus()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stderr.String(), 0
+}
+
+func TestServer(t *testing.T) {
+	home := t.TempDir()
+	if err := os.WriteFile(filepath.Join(home, "hello.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	local := t.TempDir()
+	if err := os.WriteFile(filepath.Join(local, "upload.txt"), []byte("upload"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	alice, mallory := newSigner(t), newSigner(t)
+	srv := &sshserver.Server{
+		HostKeys: []ssh.Signer{newSigner(t)},
+		Authenticate: func(meta ssh.ConnMetadata, key ssh.PublicKey) (string, error) {
+			if bytes.Equal(key.Marshal(), alice.PublicKey().Marshal()) {
+				return "alice", nil
+			}
+			return "", fmt.Errorf("unknown key")
+		},
+		FS: func(user, dir string) (utils.FS, error) {
+			return localfs.New(filepath.Join(home, dir)), nil
+		},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go srv.Serve(ln)
+	addr := ln.Addr().String()
+
+	t.Run("Pull", func(t *testing.T) {
+		dst := t.TempDir()
+		if stderr, status := transfer(t, addr, alice, "-a", dst, "hello.txt", false); status != 0 {
+			t.Fatalf("exit status %d, stderr %q", status, stderr)
+		}
+		if got, err := os.ReadFile(filepath.Join(dst, "hello.txt")); err != nil || string(got) != "hello" {
+			t.Errorf("hello.txt = %q, %v", got, err)
+		}
+	})
+
+	t.Run("Push", func(t *testing.T) {
+		if stderr, status := transfer(t, addr, alice, "-a", local, "incoming/", true); status != 0 {
+			t.Fatalf("exit status %d, stderr %q", status, stderr)
+		}
+		got, err := os.ReadFile(filepath.Join(home, "incoming", "upload.txt"))
+		if err != nil || string(got) != "upload" {
+			t.Errorf("upload.txt = %q, %v", got, err)
+		}
+	})
+
+	t.Run("ExitStatus", func(t *testing.T) {
+		client, err := ssh.Dial("tcp", addr, clientConfig(alice))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		for _, tt := range []struct {
+			command string
+			status  int
+		}{
+			{"rsync --server --no-such-option .", 1},
+			{"ls -l", 4},
+		} {
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var exitErr *ssh.ExitError
+			if err := session.Run(tt.command); !errors.As(err, &exitErr) || exitErr.ExitStatus() != tt.status {
+				t.Errorf("%q: %v, want exit status %d", tt.command, err, tt.status)
+			}
+		}
+	})
+
+	t.Run("UnknownKey", func(t *testing.T) {
+		if _, err := ssh.Dial("tcp", addr, clientConfig(mallory)); err == nil {
+			t.Errorf("unknown key was accepted")
+		}
+	})
+}
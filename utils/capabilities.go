@@ -0,0 +1,47 @@
+package utils
+
+import "github.com/picosh/go-rsync-receiver/rsync"
+
+// Capabilities restrict the operations which a transfer performs on its FS,
+// e.g. to make a module read-only regardless of the options a client sends.
+// A nil *Capabilities permits all operations.
+type Capabilities struct {
+	// AllowRead permits sending files, listing directories and reading
+	// existing files as the basis of delta transfers.
+	AllowRead bool
+	// AllowWrite permits creating and overwriting files.
+	AllowWrite bool
+	// AllowDelete permits deleting extraneous files (--delete).
+	AllowDelete bool
+	// AllowMetadata permits applying the permissions, owners and modification
+	// times sent by the peer (-p, -o, -g, -t).
+	AllowMetadata bool
+}
+
+// The Can* methods report whether c permits the respective operation.
+
+func (c *Capabilities) CanRead() bool     { return c == nil || c.AllowRead }
+func (c *Capabilities) CanWrite() bool    { return c == nil || c.AllowWrite }
+func (c *Capabilities) CanDelete() bool   { return c == nil || c.AllowDelete }
+func (c *Capabilities) CanMetadata() bool { return c == nil || c.AllowMetadata }
+
+// PermissionError is returned for operations which the Capabilities of a
+// transfer do not permit.
+type PermissionError struct {
+	// Op is the refused operation, e.g. “write” or “delete”.
+	Op string
+	// Name is the file the operation was refused for, or empty if the
+	// transfer as a whole was refused.
+	Name string
+}
+
+func (e *PermissionError) Error() string {
+	if e.Name == "" {
+		return "the server does not permit this transfer to " + e.Op + " files"
+	}
+	return e.Op + " " + e.Name + ": not permitted by the server"
+}
+
+// ExitCode makes rsync.ExitCode report refused operations like rsync does
+// for transfers to read-only modules.
+func (e *PermissionError) ExitCode() int { return int(rsync.RERR_SYNTAX) }
@@ -26,6 +26,11 @@ type ReceiverFile struct {
 	LinkTarget string
 	Rdev       int32
 	Reader     io.Reader
+
+	// Dev and Inode identify files which are hard-linked to each other (only
+	// transferred with -H).
+	Dev   int64
+	Inode int64
 }
 
 // FileMode converts from the Linux permission bits to Go’s permission bits.
@@ -51,6 +56,31 @@ func (f *ReceiverFile) FileMode() fs.FileMode {
 	return ret
 }
 
+// ModeFromFileMode converts from Go’s permission bits to the Linux permission
+// bits, i.e. it is the inverse of (*ReceiverFile).FileMode.
+func ModeFromFileMode(m fs.FileMode) int32 {
+	ret := int32(m & fs.ModePerm)
+
+	switch {
+	case m.IsDir():
+		ret |= rsync.S_IFDIR
+	case m.IsRegular():
+		ret |= rsync.S_IFREG
+	case m&fs.ModeSymlink != 0:
+		ret |= rsync.S_IFLNK
+	case m&fs.ModeCharDevice != 0:
+		ret |= rsync.S_IFCHR
+	case m&fs.ModeDevice != 0:
+		ret |= rsync.S_IFBLK
+	case m&fs.ModeNamedPipe != 0:
+		ret |= rsync.S_IFIFO
+	case m&fs.ModeSocket != 0:
+		ret |= rsync.S_IFSOCK
+	}
+
+	return ret
+}
+
 // rsync/flist.c:flist_sort_and_clean
 func SortFileList(fileList []*ReceiverFile) {
 	sort.Slice(fileList, func(i, j int) bool {
@@ -16,5 +16,38 @@ type FS interface {
 	Put(*ReceiverFile) (int64, error)
 	List(string) ([]os.FileInfo, error)
 	Read(*SenderFile) (os.FileInfo, ReaderAtCloser, error)
+	// Remove deletes all files which are not part of the given file list,
+	// which is sorted (see FindInFileList).
 	Remove([]*ReceiverFile) error
 }
+
+// Deleter is implemented by file systems which can delete individual files.
+// File systems without it delete extraneous files with Remove, which
+// compares the whole tree against the file list.
+type Deleter interface {
+	// Delete removes the file or empty directory name.
+	Delete(name string) error
+}
+
+// Linker is implemented by file systems which support hard links. File
+// systems without hard link support get a copy of the file instead.
+type Linker interface {
+	// Link creates newname as a hard link to the oldname file, replacing
+	// newname if it already exists.
+	Link(oldname, newname string) error
+}
+
+// Chowner is implemented by file systems which support file ownership. File
+// systems without it ignore the owner which a server forces on received files.
+type Chowner interface {
+	// Lchown changes the owner of name without following symbolic links. A
+	// negative id leaves the respective owner unchanged.
+	Lchown(name string, uid, gid int) error
+}
+
+// Readlinker is implemented by file systems which support symbolic links.
+// Without it, the sender omits symbolic links from the file list.
+type Readlinker interface {
+	// Readlink returns the target of the symbolic link name.
+	Readlink(name string) (string, error)
+}